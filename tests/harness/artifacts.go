@@ -0,0 +1,301 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Artifacts saves per-step debugging output for one scenario under
+// <root>/<scenario>/<NN>-<step>/ so it outlives the temp work dir.
+// <scenario> is the scenario file's path relative to the run's scenario
+// root, so same-named files in different directories stay apart.
+type Artifacts struct {
+	Dir string // scenario directory
+}
+
+// NewArtifacts creates (or empties) the scenario's artifact directory. The
+// directory is named after the scenario file's path relative to
+// scenarioRoot, falling back to its base name and then the scenario name.
+func NewArtifacts(root, scenarioRoot string, scenario Scenario) (*Artifacts, error) {
+	dir, err := filepath.Abs(filepath.Join(root, artifactsName(scenarioRoot, scenario)))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("artifacts dir: %w", err)
+	}
+	return &Artifacts{Dir: dir}, nil
+}
+
+// artifactsName slugs each segment of the scenario's path relative to
+// scenarioRoot and joins them with "--", which slugify never produces, so
+// "a/b.yaml" and "a-b.yaml" don't collide.
+func artifactsName(scenarioRoot string, scenario Scenario) string {
+	if scenario.Path == "" {
+		return slugify(scenario.Name)
+	}
+	rel := filepath.Base(scenario.Path)
+	if scenarioRoot != "" {
+		abs, err := filepath.Abs(scenario.Path)
+		if r, relErr := filepath.Rel(scenarioRoot, abs); err == nil && relErr == nil && !strings.HasPrefix(r, "..") {
+			rel = r
+		}
+	}
+	rel = strings.TrimSuffix(rel, filepath.Ext(rel))
+	var parts []string
+	for _, seg := range strings.Split(filepath.ToSlash(rel), "/") {
+		parts = append(parts, slugify(seg))
+	}
+	return strings.Join(parts, "--")
+}
+
+// StepDir creates the directory for the index'th step (0-based).
+func (a *Artifacts) StepDir(index int, stepName string) (string, error) {
+	dir := filepath.Join(a.Dir, fmt.Sprintf("%02d-%s", index+1, slugify(stepName)))
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// SaveRun writes a run step's command, stdout, and stderr.
+func (a *Artifacts) SaveRun(dir, command string, res ShellResult) error {
+	return writeArtifacts(dir, map[string]string{
+		"command.sh": command + "\n",
+		"stdout.txt": res.Stdout,
+		"stderr.txt": res.Stderr,
+		"exit_code":  fmt.Sprintf("%d\n", res.ExitCode),
+	})
+}
+
+// SavePrompt writes a prompt step's raw claude output, its session ID, and
+// the session transcript from the config dir claude ran with.
+func (a *Artifacts) SavePrompt(dir string, result *Result, env *Environ) error {
+	if result == nil {
+		return nil
+	}
+	files := map[string]string{
+		"claude.json":       string(result.Raw),
+		"claude.stderr.txt": result.Stderr,
+	}
+	if result.SessionID != "" {
+		files["session_id"] = result.SessionID + "\n"
+	}
+	if err := writeArtifacts(dir, files); err != nil {
+		return err
+	}
+	if result.SessionID == "" {
+		return nil
+	}
+	transcript := findTranscript(claudeConfigDir(env), result.SessionID)
+	if transcript == "" {
+		return nil
+	}
+	return copyFile(transcript, filepath.Join(dir, "transcript.jsonl"), 0644)
+}
+
+// SaveFile copies src into the step directory when it exists.
+func (a *Artifacts) SaveFile(dir, src, name string) error {
+	if _, err := os.Stat(src); err != nil {
+		return nil
+	}
+	return copyFile(src, filepath.Join(dir, name), 0644)
+}
+
+// BundleManifest describes a failure bundle. It is stored in the archive
+// as manifest.json and next to it as bundle-manifest.json.
+type BundleManifest struct {
+	Scenario    string    `json:"scenario"`
+	Path        string    `json:"path"`
+	FailingStep string    `json:"failing_step"` // first step with a failed assertion
+	Failures    []string  `json:"failures"`
+	Error       string    `json:"error,omitempty"`
+	BaseDir     string    `json:"base_dir"` // where the bundled tree lived
+	Rerun       string    `json:"rerun"`
+	Created     time.Time `json:"created"`
+}
+
+// newBundleManifest summarizes a failed run for its bundle.
+func newBundleManifest(scenario Scenario, results []StepResult, reportErr, baseDir, rerun string) BundleManifest {
+	m := BundleManifest{
+		Scenario: scenario.Name,
+		Path:     scenario.Path,
+		Error:    reportErr,
+		BaseDir:  baseDir,
+		Rerun:    rerun,
+		Created:  time.Now().UTC(),
+	}
+	for _, r := range results {
+		if r.Pass {
+			continue
+		}
+		if m.FailingStep == "" {
+			m.FailingStep = r.StepName
+		}
+		failure := r.StepName + ": " + assertionSummary(r.Assertion)
+		if r.Detail != "" {
+			failure += ": " + strings.SplitN(r.Detail, "\n", 2)[0]
+		}
+		m.Failures = append(m.Failures, failure)
+	}
+	return m
+}
+
+// Bundle archives baseDir (project, remote, and local plugins) as
+// workdir.tar.gz with the manifest. Symlinks are stored as links. An empty
+// baseDir (the scenario failed before it had a tree) archives the manifest
+// alone.
+func (a *Artifacts) Bundle(baseDir string, manifest BundleManifest) (string, error) {
+	// No HTML escaping, so the rerun command stays copy-pasteable
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return "", err
+	}
+	manifestJSON := buf.Bytes()
+	if err := os.WriteFile(filepath.Join(a.Dir, "bundle-manifest.json"), manifestJSON, 0644); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(a.Dir, "workdir.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name: "manifest.json", Mode: 0644, Size: int64(len(manifestJSON)), ModTime: manifest.Created,
+	}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(manifestJSON); err != nil {
+		return "", err
+	}
+
+	if baseDir != "" {
+		root := filepath.Base(baseDir)
+		err = filepath.Walk(baseDir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(baseDir, p)
+			if err != nil {
+				return err
+			}
+			link := ""
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(p); err != nil {
+					return err
+				}
+			}
+			hdr, err := tar.FileInfoHeader(fi, link)
+			if err != nil {
+				return nil // sockets and other special files
+			}
+			hdr.Name = filepath.ToSlash(filepath.Join(root, rel))
+			if fi.IsDir() {
+				hdr.Name += "/"
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			src, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			_, err = io.Copy(tw, src)
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("bundling %s: %w", baseDir, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeArtifacts writes the non-empty files in dir.
+func writeArtifacts(dir string, files map[string]string) error {
+	for name, content := range files {
+		if content == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claudeConfigDir is where claude keeps sessions for env: CLAUDE_CONFIG_DIR
+// when set (always, in hermetic mode), otherwise ~/.claude.
+func claudeConfigDir(env *Environ) string {
+	vars := env.Vars()
+	if dir := lookupVar(vars, "CLAUDE_CONFIG_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(lookupVar(vars, "HOME"), ".claude")
+}
+
+// findTranscript locates projects/<project>/<session>.jsonl under configDir.
+func findTranscript(configDir, sessionID string) string {
+	matches, _ := filepath.Glob(filepath.Join(configDir, "projects", "*", sessionID+".jsonl"))
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+// lookupVar returns the last value of key in a KEY=value list, matching
+// exec's rule that later entries win.
+func lookupVar(vars []string, key string) string {
+	value := ""
+	for _, kv := range vars {
+		if strings.HasPrefix(kv, key+"=") {
+			value = kv[len(key)+1:]
+		}
+	}
+	return value
+}
+
+// slugify makes a name safe for a file name.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '_' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimRight(b.String(), "-")
+	if slug == "" {
+		slug = "unnamed"
+	}
+	return slug
+}
@@ -10,156 +10,194 @@ import (
 	"time"
 )
 
-// checkAssertion evaluates a single assertion against the current state.
-func checkAssertion(workDir string, a Assertion, output string, exitCode int) (bool, string) {
-	var result bool
-	var detail string
+// assertionKind is one assertion type: how to check it and whether it
+// needs a path. lint reads the same table, so the two can't drift apart.
+type assertionKind struct {
+	needsPath bool
+	check     func(workDir string, a Assertion, outcome StepOutcome) (bool, string)
+}
 
-	switch a.Type {
-	case "file_exists":
-		path := filepath.Join(workDir, a.Path)
-		_, err := os.Stat(path)
-		result = err == nil
-		if !result {
-			detail = fmt.Sprintf("file %q does not exist", a.Path)
+// assertionKinds maps every assertion type to its kind.
+var assertionKinds = map[string]assertionKind{
+	"file_exists": {needsPath: true, check: func(workDir string, a Assertion, _ StepOutcome) (bool, string) {
+		_, err := os.Stat(filepath.Join(workDir, a.Path))
+		if err != nil {
+			return false, fmt.Sprintf("file %q does not exist", a.Path)
 		}
+		return true, ""
+	}},
 
-	case "file_not_exists":
-		path := filepath.Join(workDir, a.Path)
-		_, err := os.Stat(path)
-		result = os.IsNotExist(err)
-		if !result {
-			detail = fmt.Sprintf("file %q exists (expected not to)", a.Path)
+	"file_not_exists": {needsPath: true, check: func(workDir string, a Assertion, _ StepOutcome) (bool, string) {
+		_, err := os.Stat(filepath.Join(workDir, a.Path))
+		if !os.IsNotExist(err) {
+			return false, fmt.Sprintf("file %q exists (expected not to)", a.Path)
 		}
+		return true, ""
+	}},
 
-	case "file_contains":
-		path := filepath.Join(workDir, a.Path)
-		data, err := os.ReadFile(path)
+	"file_contains": {needsPath: true, check: func(workDir string, a Assertion, _ StepOutcome) (bool, string) {
+		data, err := os.ReadFile(filepath.Join(workDir, a.Path))
 		if err != nil {
-			result = false
-			detail = fmt.Sprintf("cannot read %q: %v", a.Path, err)
-		} else {
-			result = strings.Contains(string(data), a.Value)
-			if !result {
-				detail = fmt.Sprintf("file %q does not contain %q", a.Path, a.Value)
-			}
+			return false, fmt.Sprintf("cannot read %q: %v", a.Path, err)
 		}
+		if !strings.Contains(string(data), a.Value) {
+			return false, fmt.Sprintf("file %q does not contain %q", a.Path, a.Value)
+		}
+		return true, ""
+	}},
 
-	case "file_not_contains":
-		path := filepath.Join(workDir, a.Path)
-		data, err := os.ReadFile(path)
+	"file_not_contains": {needsPath: true, check: func(workDir string, a Assertion, _ StepOutcome) (bool, string) {
+		data, err := os.ReadFile(filepath.Join(workDir, a.Path))
 		if err != nil {
 			// File doesn't exist → content can't contain value → pass
-			result = true
-		} else {
-			result = !strings.Contains(string(data), a.Value)
-			if !result {
-				detail = fmt.Sprintf("file %q contains %q (expected not to)", a.Path, a.Value)
-			}
+			return true, ""
+		}
+		if strings.Contains(string(data), a.Value) {
+			return false, fmt.Sprintf("file %q contains %q (expected not to)", a.Path, a.Value)
 		}
+		return true, ""
+	}},
 
-	case "output_contains":
-		result = strings.Contains(output, a.Value)
-		if !result {
+	"output_contains": {check: func(_ string, a Assertion, outcome StepOutcome) (bool, string) {
+		if !strings.Contains(outcome.Output, a.Value) {
 			// Show truncated output for debugging
-			truncated := output
+			truncated := outcome.Output
 			if len(truncated) > 200 {
 				truncated = truncated[:200] + "..."
 			}
-			detail = fmt.Sprintf("output does not contain %q (got: %s)", a.Value, truncated)
+			return false, fmt.Sprintf("output does not contain %q (got: %s)", a.Value, truncated)
 		}
+		return true, ""
+	}},
 
-	case "output_not_contains":
-		result = !strings.Contains(output, a.Value)
-		if !result {
-			detail = fmt.Sprintf("output contains %q (expected not to)", a.Value)
+	"output_not_contains": {check: func(_ string, a Assertion, outcome StepOutcome) (bool, string) {
+		if strings.Contains(outcome.Output, a.Value) {
+			return false, fmt.Sprintf("output contains %q (expected not to)", a.Value)
 		}
+		return true, ""
+	}},
 
-	case "exit_code":
+	"exit_code": {check: func(_ string, a Assertion, outcome StepOutcome) (bool, string) {
 		expected, err := strconv.Atoi(a.Value)
 		if err != nil {
-			result = false
-			detail = fmt.Sprintf("invalid exit_code value %q", a.Value)
-		} else {
-			result = exitCode == expected
-			if !result {
-				detail = fmt.Sprintf("exit code %d != expected %d", exitCode, expected)
-			}
+			return false, fmt.Sprintf("invalid exit_code value %q", a.Value)
+		}
+		if outcome.ExitCode != expected {
+			return false, fmt.Sprintf("exit code %d != expected %d", outcome.ExitCode, expected)
 		}
+		return true, ""
+	}},
 
-	case "json_field":
-		path := filepath.Join(workDir, a.Path)
-		data, err := os.ReadFile(path)
+	"json_field": {needsPath: true, check: func(workDir string, a Assertion, _ StepOutcome) (bool, string) {
+		data, err := os.ReadFile(filepath.Join(workDir, a.Path))
 		if err != nil {
-			result = false
-			detail = fmt.Sprintf("cannot read %q: %v", a.Path, err)
-		} else {
-			result = checkJSONField(data, a.Value)
-			if !result {
-				detail = fmt.Sprintf("JSON field check failed for %q in %q", a.Value, a.Path)
-			}
+			return false, fmt.Sprintf("cannot read %q: %v", a.Path, err)
 		}
+		if !checkJSONField(data, a.Value) {
+			return false, fmt.Sprintf("JSON field check failed for %q in %q", a.Value, a.Path)
+		}
+		return true, ""
+	}},
 
-	case "git_log_contains":
-		out, code := runAssertionCmd(workDir, "git log --oneline 2>/dev/null")
+	"git_log_contains": {check: func(workDir string, a Assertion, outcome StepOutcome) (bool, string) {
+		out, code := runAssertionCmd(outcome.Env, workDir, "git log --oneline 2>/dev/null")
 		if code != 0 {
-			result = false
-			detail = "git log failed"
-		} else {
-			result = strings.Contains(out, a.Value)
-			if !result {
-				detail = fmt.Sprintf("git log does not contain %q", a.Value)
-			}
+			return false, "git log failed"
+		}
+		if !strings.Contains(out, a.Value) {
+			return false, fmt.Sprintf("git log does not contain %q", a.Value)
 		}
+		return true, ""
+	}},
 
-	case "git_status_clean":
-		out, code := runAssertionCmd(workDir, "git status --porcelain 2>/dev/null")
+	"git_status_clean": {check: func(workDir string, _ Assertion, outcome StepOutcome) (bool, string) {
+		out, code := runAssertionCmd(outcome.Env, workDir, "git status --porcelain 2>/dev/null")
 		if code != 0 {
-			result = false
-			detail = "git status failed"
-		} else {
-			result = strings.TrimSpace(out) == ""
-			if !result {
-				detail = fmt.Sprintf("git working tree is not clean: %s", strings.TrimSpace(out))
-			}
+			return false, "git status failed"
+		}
+		if strings.TrimSpace(out) != "" {
+			return false, fmt.Sprintf("git working tree is not clean: %s", strings.TrimSpace(out))
 		}
+		return true, ""
+	}},
 
-	case "remote_has_ref":
+	"remote_has_ref": {needsPath: true, check: func(workDir string, a Assertion, outcome StepOutcome) (bool, string) {
 		cmd := fmt.Sprintf("git -C %q show-ref --verify %s 2>/dev/null", a.Path, a.Value)
-		_, code := runAssertionCmd(workDir, cmd)
-		result = code == 0
-		if !result {
-			detail = fmt.Sprintf("remote %q does not have ref %q", a.Path, a.Value)
+		if _, code := runAssertionCmd(outcome.Env, workDir, cmd); code != 0 {
+			return false, fmt.Sprintf("remote %q does not have ref %q", a.Path, a.Value)
 		}
+		return true, ""
+	}},
 
-	case "symlink_exists":
-		path := filepath.Join(workDir, a.Path)
-		fi, err := os.Lstat(path)
+	"symlink_exists": {needsPath: true, check: func(workDir string, a Assertion, _ StepOutcome) (bool, string) {
+		fi, err := os.Lstat(filepath.Join(workDir, a.Path))
 		if err != nil {
-			result = false
-			detail = fmt.Sprintf("path %q does not exist", a.Path)
-		} else {
-			result = fi.Mode()&os.ModeSymlink != 0
-			if !result {
-				detail = fmt.Sprintf("path %q exists but is not a symlink", a.Path)
-			}
+			return false, fmt.Sprintf("path %q does not exist", a.Path)
 		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return false, fmt.Sprintf("path %q exists but is not a symlink", a.Path)
+		}
+		return true, ""
+	}},
 
-	case "config_value":
-		path := filepath.Join(workDir, a.Path)
-		data, err := os.ReadFile(path)
+	"config_value": {needsPath: true, check: func(workDir string, a Assertion, _ StepOutcome) (bool, string) {
+		data, err := os.ReadFile(filepath.Join(workDir, a.Path))
 		if err != nil {
-			result = false
-			detail = fmt.Sprintf("cannot read %q: %v", a.Path, err)
-		} else {
-			result = checkJSONField(data, a.Value)
-			if !result {
-				detail = fmt.Sprintf("config field check failed for %q in %q", a.Value, a.Path)
-			}
+			return false, fmt.Sprintf("cannot read %q: %v", a.Path, err)
+		}
+		if !checkJSONField(data, a.Value) {
+			return false, fmt.Sprintf("config field check failed for %q in %q", a.Value, a.Path)
+		}
+		return true, ""
+	}},
+
+	"files_created":  {check: checkDiffKind},
+	"files_modified": {check: checkDiffKind},
+	"files_deleted":  {check: checkDiffKind},
+	"only_changed":   {check: checkDiffKind},
+
+	"worktree_exists": {check: checkWorktreeKind},
+	"worktree_branch": {check: checkWorktreeKind},
+	"worktree_count":  {check: checkWorktreeKind},
+
+	"request_contains":       {check: checkRequestKind},
+	"system_prompt_contains": {check: checkRequestKind},
+
+	"hook_invoked": {check: checkHookKind},
+	"hook_order":   {check: checkHookKind},
+
+	"session_ok": {check: func(_ string, _ Assertion, outcome StepOutcome) (bool, string) {
+		if outcome.SessionError != "" {
+			return false, sessionErrorDetail(outcome.SessionError, outcome.Output)
 		}
+		return true, ""
+	}},
+}
+
+// Adapters from the assertion families' checkers to assertionKind.check.
+func checkDiffKind(_ string, a Assertion, outcome StepOutcome) (bool, string) {
+	return checkDiffAssertion(a, outcome.Diff)
+}
+
+func checkWorktreeKind(workDir string, a Assertion, outcome StepOutcome) (bool, string) {
+	return checkWorktreeAssertion(outcome.Env, workDir, a)
+}
 
-	default:
-		result = false
+func checkRequestKind(_ string, a Assertion, outcome StepOutcome) (bool, string) {
+	return checkRequestAssertion(a, outcome.Requests)
+}
+
+func checkHookKind(_ string, a Assertion, outcome StepOutcome) (bool, string) {
+	return checkHookAssertion(a, outcome.Hooks, outcome.HooksTraced)
+}
+
+// checkAssertion evaluates a single assertion against the current state.
+func checkAssertion(workDir string, a Assertion, outcome StepOutcome) (bool, string) {
+	var result bool
+	var detail string
+	if kind, ok := assertionKinds[a.Type]; ok {
+		result, detail = kind.check(workDir, a, outcome)
+	} else {
 		detail = fmt.Sprintf("unknown assertion type %q", a.Type)
 	}
 
@@ -175,9 +213,33 @@ func checkAssertion(workDir string, a Assertion, output string, exitCode int) (b
 	return result, detail
 }
 
+// checkRequestAssertion looks for a.Value in the captured model requests:
+// anywhere in the request for request_contains, or in the system prompt,
+// system messages, and <system-reminder> context (CLAUDE.md, rules,
+// SessionStart output) for system_prompt_contains. Any one request matching is enough.
+func checkRequestAssertion(a Assertion, requests []MockRequest) (bool, string) {
+	if len(requests) == 0 {
+		return false, "no model requests captured (the scenario must use the mock API)"
+	}
+	where := "any model request"
+	if a.Type == "system_prompt_contains" {
+		where = "any system prompt"
+	}
+	for _, req := range requests {
+		text := req.Text
+		if a.Type == "system_prompt_contains" {
+			text = req.System
+		}
+		if strings.Contains(text, a.Value) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("%s does not contain %q (%d requests captured)", where, a.Value, len(requests))
+}
+
 // runAssertionCmd runs a shell command for assertion checking.
-func runAssertionCmd(workDir, command string) (string, int) {
-	return runShell(workDir, command, "", nil, 30*time.Second)
+func runAssertionCmd(env *Environ, workDir, command string) (string, int) {
+	return runShell(env, workDir, command, "", nil, 30*time.Second)
 }
 
 // checkJSONField does a simple key existence check in JSON data.
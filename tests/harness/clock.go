@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock pins the time seen by scenario processes. It owns a temp directory
+// holding the current epoch and a `date` shim that reports it, so scripts
+// calling `date` get the scenario's time instead of the wall clock.
+type Clock struct {
+	dir string
+	now time.Time
+}
+
+// dateShimGNU and dateShimBSD wrap the real date binary. Without a date
+// argument they report the epoch in $HARNESS_CLOCK_FILE. GNU date's -d
+// passes @epoch and absolute dates through but anchors relative ones
+// ("-7 days", "yesterday", "2 hours ago") to the pinned time by prepending
+// it; weekday items ("next monday") stay unanchored because date ignores
+// them next to a calendar date, and dates that start with a weekday, as
+// date and date -R print them, pass through as absolute. BSD date's -v
+// adjustments apply to the pinned time already. Other flags that name
+// their own time (-r, -f, -s, -j) are passed through untouched.
+const dateShimGNU = `#!/bin/bash
+# date shim installed by the test harness (GNU date)
+REAL=%q
+NOW=$(cat "$HARNESS_CLOCK_FILE" 2>/dev/null) || exec "$REAL" "$@"
+
+# anchor EXPR: prints EXPR relative to the pinned time, absolute ones as is
+anchor() {
+  case "$1" in
+    @*|[0-9][0-9][0-9][0-9]-*|[0-9]*/*|[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]*) printf '%%s' "$1" ;;
+    [Jj]an*|[Ff]eb*|[Mm]ar*|[Aa]pr*|[Mm]ay*|[Jj]un*|[Jj]ul*|[Aa]ug*|[Ss]ep*|[Oo]ct*|[Nn]ov*|[Dd]ec*) printf '%%s' "$1" ;;
+    [Mm]on|[Mm]on[!t]*|[Tt]ue*|[Ww]ed*|[Tt]hu*|[Ff]ri*|[Ss]at*|[Ss]un*) printf '%%s' "$1" ;;
+    *:*) printf '%%s %%s' "$("$REAL" -d "@$NOW" '+%%Y-%%m-%%d')" "$1" ;;
+    *) printf '%%s %%s' "$("$REAL" -d "@$NOW" '+%%Y-%%m-%%d %%H:%%M:%%S %%z')" "$1" ;;
+  esac
+}
+
+ARGS=() DATED=
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -d|--date) ARGS+=("$1" "$(anchor "$2")"); DATED=1; shift ;;
+    --date=*) ARGS+=("--date=$(anchor "${1#--date=}")"); DATED=1 ;;
+    -d*) ARGS+=("-d" "$(anchor "${1#-d}")"); DATED=1 ;;
+    -r|-r*|--reference|--reference=*|-f|-f*|--file|--file=*|-s|-s*|--set|--set=*)
+      exec "$REAL" "${ARGS[@]}" "$@" ;;
+    *) ARGS+=("$1") ;;
+  esac
+  shift
+done
+[ -n "$DATED" ] && exec "$REAL" "${ARGS[@]}"
+exec "$REAL" -d "@$NOW" "${ARGS[@]}"
+`
+
+const dateShimBSD = `#!/bin/bash
+# date shim installed by the test harness (BSD date)
+REAL=%q
+NOW=$(cat "$HARNESS_CLOCK_FILE" 2>/dev/null) || exec "$REAL" "$@"
+for arg in "$@"; do
+  case "$arg" in
+    -r|-r*|-f|-f*|-j)
+      exec "$REAL" "$@" ;;
+  esac
+done
+exec "$REAL" -r "$NOW" "$@"
+`
+
+// NewClock creates a clock pinned to now (RFC 3339).
+func NewClock(now string) (*Clock, error) {
+	t, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		return nil, fmt.Errorf("now: %w", err)
+	}
+	realDate, err := exec.LookPath("date")
+	if err != nil {
+		return nil, fmt.Errorf("locating date: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "test-clock-*")
+	if err != nil {
+		return nil, fmt.Errorf("mkdirTemp: %w", err)
+	}
+	c := &Clock{dir: dir, now: t}
+	if err := c.install(realDate); err != nil {
+		c.Cleanup()
+		return nil, err
+	}
+	return c, nil
+}
+
+// install writes the date shim for realDate's flavor and the epoch file.
+func (c *Clock) install(realDate string) error {
+	shim := dateShimBSD
+	if exec.Command(realDate, "--version").Run() == nil {
+		shim = dateShimGNU
+	}
+	if err := os.MkdirAll(c.BinDir(), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(c.BinDir(), "date"), []byte(fmt.Sprintf(shim, realDate)), 0755); err != nil {
+		return err
+	}
+	return c.write()
+}
+
+// Now returns the pinned time.
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) error {
+	c.now = c.now.Add(d)
+	return c.write()
+}
+
+// Set moves the clock to t, e.g. back to where a retried step started.
+func (c *Clock) Set(t time.Time) error {
+	c.now = t
+	return c.write()
+}
+
+// BinDir is prepended to PATH so the shim shadows the real date.
+func (c *Clock) BinDir() string {
+	return filepath.Join(c.dir, "bin")
+}
+
+// File holds the current epoch seconds, read by the shim on every call.
+func (c *Clock) File() string {
+	return filepath.Join(c.dir, "now")
+}
+
+// Cleanup removes the shim directory.
+func (c *Clock) Cleanup() {
+	if c != nil && c.dir != "" {
+		os.RemoveAll(c.dir)
+	}
+}
+
+func (c *Clock) write() error {
+	return os.WriteFile(c.File(), []byte(strconv.FormatInt(c.now.Unix(), 10)), 0644)
+}
+
+// parseClockDuration extends time.ParseDuration with a "d" (24h) unit,
+// e.g. "2d" or "1d12h".
+func parseClockDuration(s string) (time.Duration, error) {
+	if i := strings.Index(s, "d"); i > 0 {
+		days, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest := time.Duration(0)
+		if s[i+1:] != "" {
+			if rest, err = time.ParseDuration(s[i+1:]); err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+		}
+		return time.Duration(days)*24*time.Hour + rest, nil
+	}
+	return time.ParseDuration(s)
+}
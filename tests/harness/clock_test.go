@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDateShimGNU(t *testing.T) {
+	if exec.Command("date", "--version").Run() != nil {
+		t.Skip("date is not GNU date")
+	}
+	clock, err := NewClock("2026-03-10T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clock.Cleanup()
+
+	shim := filepath.Join(clock.BinDir(), "date")
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no date", []string{"+%F %T"}, "2026-03-10 12:00:00"},
+		{"relative", []string{"-d", "2 days ago", "+%F %T"}, "2026-03-08 12:00:00"},
+		{"relative with time", []string{"-d", "tomorrow 10:00", "+%F %T"}, "2026-03-11 10:00:00"},
+		{"yesterday", []string{"--date=yesterday", "+%F"}, "2026-03-09"},
+		{"epoch", []string{"-d", "@0", "+%F"}, "1970-01-01"},
+		{"iso", []string{"-d", "2024-01-02 03:04:05", "+%F %T"}, "2024-01-02 03:04:05"},
+		{"month name", []string{"-d", "Jan 5 2024", "+%F"}, "2024-01-05"},
+		{"date output", []string{"-d", "Tue Mar 10 12:00:00 UTC 2026", "+%F %T"}, "2026-03-10 12:00:00"},
+		{"date -R output", []string{"-d", "Tue, 10 Mar 2026 12:00:00 +0000", "+%F %T"}, "2026-03-10 12:00:00"},
+		{"lowercase weekday", []string{"-d", "mon, 9 mar 2026 00:00:00 +0000", "+%F"}, "2026-03-09"},
+	}
+	for _, tc := range cases {
+		cmd := exec.Command(shim, tc.args...)
+		cmd.Env = append(os.Environ(), "HARNESS_CLOCK_FILE="+clock.File(), "TZ=UTC")
+		out, err := cmd.CombinedOutput()
+		got := strings.TrimSpace(string(out))
+		if err != nil || got != tc.want {
+			t.Errorf("%s: date %q = %q (%v), want %q", tc.name, tc.args, got, err, tc.want)
+		}
+	}
+}
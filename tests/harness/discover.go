@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scenarioList is the manifest file name. A directory holding one runs the
+// scenarios it lists instead of every YAML file below it.
+const scenarioList = "scenarios.list"
+
+// discoverScenarios expands command-line arguments into scenario files.
+// An argument may be a file, a directory (walked recursively, skipping
+// fixtures and hidden directories), a glob where ** spans directories, or a
+// scenarios.list manifest. Each argument's files are sorted; files already
+// seen are dropped, so the order is that of the arguments.
+func discoverScenarios(args []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, arg := range args {
+		found, err := expandScenarioArg(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range found {
+			key := p
+			if abs, err := filepath.Abs(p); err == nil {
+				key = abs
+			}
+			if !seen[key] {
+				seen[key] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// expandScenarioArg expands one argument; depth guards against manifests
+// that include each other.
+func expandScenarioArg(arg string, depth int) ([]string, error) {
+	if depth > 8 {
+		return nil, fmt.Errorf("%s: manifests nested too deeply", arg)
+	}
+	if hasGlobMeta(arg) {
+		matches, err := globScenarios(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no scenarios match %s", arg)
+		}
+		return matches, nil
+	}
+
+	fi, err := os.Stat(arg)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		if _, err := os.Stat(filepath.Join(arg, scenarioList)); err == nil {
+			return readScenarioList(filepath.Join(arg, scenarioList), depth)
+		}
+		return walkScenarios(arg, func(string) bool { return true })
+	}
+	if filepath.Base(arg) == scenarioList {
+		return readScenarioList(arg, depth)
+	}
+	return []string{arg}, nil
+}
+
+// readScenarioList expands each entry of a manifest: one file, directory,
+// or glob per line, relative to the manifest. Blank lines and # comments
+// are ignored.
+func readScenarioList(path string, depth int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if !filepath.IsAbs(entry) {
+			entry = filepath.Join(filepath.Dir(path), entry)
+		}
+		found, err := expandScenarioArg(entry, depth+1)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		paths = append(paths, found...)
+	}
+	return paths, scanner.Err()
+}
+
+// globScenarios walks the pattern's fixed leading directories and keeps
+// the scenario files the rest of the pattern matches (see matchGlob).
+func globScenarios(pattern string) ([]string, error) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	fixed := 0
+	for fixed < len(segments) && !hasGlobMeta(segments[fixed]) {
+		fixed++
+	}
+	root := strings.Join(segments[:fixed], "/")
+	if root == "" && strings.HasPrefix(pattern, "/") {
+		root = "/"
+	} else if root == "" {
+		root = "."
+	}
+	rest := strings.Join(segments[fixed:], "/")
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return walkScenarios(root, func(rel string) bool { return matchGlob(rest, rel) })
+}
+
+// walkScenarios returns the YAML files under root, in lexical order, whose
+// slash-separated path relative to root satisfies match.
+func walkScenarios(root string, match func(rel string) bool) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path != root && (fi.Name() == "fixtures" || strings.HasPrefix(fi.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if match(filepath.ToSlash(rel)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// commonDir returns the deepest directory holding every path, as an
+// absolute path.
+func commonDir(paths []string) string {
+	var common []string
+	for i, p := range paths {
+		abs, err := filepath.Abs(filepath.Dir(p))
+		if err != nil {
+			return ""
+		}
+		segs := strings.Split(filepath.ToSlash(abs), "/")
+		if i == 0 {
+			common = segs
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(segs) && common[n] == segs[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) == 0 {
+		return ""
+	}
+	return filepath.Clean(filepath.FromSlash(strings.Join(common, "/") + "/"))
+}
+
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Environ builds the base environment for every process the harness starts.
+// A nil or non-hermetic Environ inherits os.Environ(). Hermetic mode starts
+// from nothing: a temp HOME, no global or system git config, a PATH limited
+// to system directories, and a fixed TZ and LANG. Host variables are passed
+// through only when named in Pass. Either mode can pin time with a Clock.
+type Environ struct {
+	Hermetic bool
+	Home     string   // temp HOME (hermetic only)
+	Pass     []string // host variables to pass through (hermetic only)
+	Clock    *Clock   // nil uses the wall clock
+}
+
+// hermeticPathDirs are kept on PATH in hermetic mode when they exist.
+var hermeticPathDirs = []string{
+	"/usr/local/bin", "/opt/homebrew/bin", "/usr/bin", "/bin", "/usr/sbin", "/sbin",
+}
+
+// hermeticTools are located on the host PATH so their directories stay
+// reachable even when installed outside the system directories.
+var hermeticTools = []string{"bash", "git", "jq", "python3", "claude"}
+
+// NewEnviron returns the environment for a scenario. In hermetic mode it
+// creates a temp HOME that Cleanup removes.
+func NewEnviron(hermetic bool, pass []string) (*Environ, error) {
+	if !hermetic {
+		return &Environ{}, nil
+	}
+	home, err := os.MkdirTemp("", "test-home-*")
+	if err != nil {
+		return nil, fmt.Errorf("mkdirTemp: %w", err)
+	}
+	return &Environ{Hermetic: true, Home: home, Pass: pass}, nil
+}
+
+// Vars returns the base environment as KEY=value pairs.
+func (e *Environ) Vars() []string {
+	if e == nil {
+		return os.Environ()
+	}
+	vars := e.baseVars()
+
+	// Later entries win, so these override anything inherited
+	if e.Clock != nil {
+		path := os.Getenv("PATH")
+		if e.Hermetic {
+			path = hermeticPath()
+		}
+		date := e.Clock.Now().Format(time.RFC3339)
+		vars = append(vars,
+			"PATH="+e.Clock.BinDir()+string(os.PathListSeparator)+path,
+			"HARNESS_CLOCK_FILE="+e.Clock.File(),
+			"GIT_AUTHOR_DATE="+date,
+			"GIT_COMMITTER_DATE="+date,
+		)
+	}
+	return vars
+}
+
+// Now returns the pinned time, or the wall clock when time isn't pinned.
+func (e *Environ) Now() time.Time {
+	if e != nil && e.Clock != nil {
+		return e.Clock.Now()
+	}
+	return time.Now()
+}
+
+func (e *Environ) baseVars() []string {
+	if !e.Hermetic {
+		return os.Environ()
+	}
+
+	vars := []string{
+		"HOME=" + e.Home,
+		"USER=tester",
+		"LOGNAME=tester",
+		"PATH=" + hermeticPath(),
+		"SHELL=/bin/bash",
+		"TZ=UTC",
+		"LANG=C.UTF-8",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"CLAUDE_CONFIG_DIR=" + filepath.Join(e.Home, ".claude"),
+	}
+	if tmp := os.Getenv("TMPDIR"); tmp != "" {
+		vars = append(vars, "TMPDIR="+tmp)
+	}
+	for _, name := range e.Pass {
+		if val, ok := os.LookupEnv(name); ok {
+			vars = append(vars, name+"="+val)
+		}
+	}
+	return vars
+}
+
+// Cleanup removes the temp HOME and clock shim.
+func (e *Environ) Cleanup() {
+	if e == nil {
+		return
+	}
+	if e.Home != "" {
+		os.RemoveAll(e.Home)
+	}
+	e.Clock.Cleanup()
+}
+
+// hermeticPath joins the system directories and the directories holding
+// the tools scenarios rely on, without duplicates.
+func hermeticPath() string {
+	var dirs []string
+	seen := map[string]bool{}
+	add := func(dir string) {
+		if dir == "" || seen[dir] {
+			return
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range hermeticPathDirs {
+		add(dir)
+	}
+	for _, tool := range hermeticTools {
+		if path, err := exec.LookPath(tool); err == nil {
+			add(filepath.Dir(path))
+		}
+	}
+	return strings.Join(dirs, string(os.PathListSeparator))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileSpec is an inline project file. In YAML it is either a plain string
+// (the contents, written 0644) or a mapping with the fields below.
+type FileSpec struct {
+	Content string `yaml:"content"`
+	Base64  string `yaml:"base64"`  // binary contents; overrides content
+	Mode    string `yaml:"mode"`    // octal permissions, e.g. "0755"
+	Symlink string `yaml:"symlink"` // create a symlink to this target instead of a file
+}
+
+// UnmarshalYAML accepts both the scalar and mapping forms.
+func (f *FileSpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Content = node.Value
+		return nil
+	}
+	type plain FileSpec
+	return node.Decode((*plain)(f))
+}
+
+// write creates the file (or symlink) at absPath.
+func (f FileSpec) write(absPath string) error {
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return err
+	}
+	if f.Symlink != "" {
+		os.Remove(absPath)
+		return os.Symlink(f.Symlink, absPath)
+	}
+
+	data := []byte(f.Content)
+	if f.Base64 != "" {
+		decoded, err := base64.StdEncoding.DecodeString(f.Base64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid base64: %w", absPath, err)
+		}
+		data = decoded
+	}
+
+	mode := os.FileMode(0644)
+	if f.Mode != "" {
+		m, err := strconv.ParseUint(f.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("%s: invalid mode %q", absPath, f.Mode)
+		}
+		mode = os.FileMode(m)
+	}
+	if err := os.WriteFile(absPath, data, mode); err != nil {
+		return err
+	}
+	// WriteFile leaves an existing file's mode alone and is subject to umask
+	return os.Chmod(absPath, mode)
+}
+
+// copyTree copies src into dst, preserving file modes and symlinks
+// (links are recreated with the same target, not followed).
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case fi.IsDir():
+			// Keep directories owner-writable so their contents can be copied
+			return os.MkdirAll(target, fi.Mode().Perm()|0700)
+		case fi.Mode().IsRegular():
+			return copyFile(path, target, fi.Mode().Perm())
+		default:
+			return nil // sockets, fifos, devices
+		}
+	})
+}
+
+// copyFile copies a regular file with the given permissions.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Forks checkpoints conversation and filesystem state after steps that a
+// later step's fork_from names, so several branches can continue from the
+// same point. A checkpoint is keyed by step name and by session name; the
+// session key always holds that session's latest checkpoint.
+type Forks struct {
+	dir         string          // temp directory holding the snapshots
+	dirs        []string        // directories snapshotted (work dir, remote)
+	wanted      map[string]bool // names some step forks from
+	checkpoints map[string]*checkpoint
+}
+
+type checkpoint struct {
+	SessionID string
+	Allowed   []string
+	Snapshot  string // holds one copy per entry of Forks.dirs
+}
+
+// NewForks prepares checkpoints for the scenario's fork_from references.
+// It returns nil when no step forks.
+func NewForks(steps []Step, dirs ...string) (*Forks, error) {
+	wanted := map[string]bool{}
+	for _, step := range steps {
+		if step.ForkFrom != "" {
+			wanted[step.ForkFrom] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp("", "test-forks-*")
+	if err != nil {
+		return nil, fmt.Errorf("mkdirTemp: %w", err)
+	}
+	var keep []string
+	for _, d := range dirs {
+		if d != "" {
+			keep = append(keep, d)
+		}
+	}
+	return &Forks{dir: dir, dirs: keep, wanted: wanted, checkpoints: map[string]*checkpoint{}}, nil
+}
+
+// Save checkpoints the state after a step when a later step forks from the
+// step or its session.
+func (f *Forks) Save(index int, stepName, sessionName string, session *Session) error {
+	if f == nil || !(f.wanted[stepName] || f.wanted[sessionName]) {
+		return nil
+	}
+	snap := filepath.Join(f.dir, fmt.Sprintf("%02d", index+1))
+	for i, d := range f.dirs {
+		if err := copyTree(d, filepath.Join(snap, fmt.Sprint(i))); err != nil {
+			return fmt.Errorf("checkpoint %q: %w", stepName, err)
+		}
+	}
+	cp := &checkpoint{
+		SessionID: session.ID,
+		Allowed:   append([]string(nil), session.Allowed...),
+		Snapshot:  snap,
+	}
+	if f.wanted[stepName] {
+		f.checkpoints[stepName] = cp
+	}
+	if f.wanted[sessionName] {
+		f.checkpoints[sessionName] = cp
+	}
+	// claude appends to a resumed session's transcript, so the session
+	// carrying on from here must fork too or it would rewrite the checkpoint
+	session.Fork = session.ID != ""
+	return nil
+}
+
+// Restore rewinds the snapshotted directories to the checkpoint named by
+// fork_from and returns it.
+func (f *Forks) Restore(name string) (*checkpoint, error) {
+	if f == nil {
+		return nil, fmt.Errorf("no checkpoint for %q", name)
+	}
+	cp, ok := f.checkpoints[name]
+	if !ok {
+		return nil, fmt.Errorf("no checkpoint for %q (the step did not run)", name)
+	}
+	for i, d := range f.dirs {
+		if err := clearDir(d); err != nil {
+			return nil, err
+		}
+		if err := copyTree(filepath.Join(cp.Snapshot, fmt.Sprint(i)), d); err != nil {
+			return nil, fmt.Errorf("restoring %q: %w", name, err)
+		}
+	}
+	return cp, nil
+}
+
+// Cleanup removes the snapshots.
+func (f *Forks) Cleanup() {
+	if f != nil {
+		os.RemoveAll(f.dir)
+	}
+}
+
+// clearDir removes everything inside dir, keeping dir itself so processes
+// and paths that refer to it stay valid.
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateForks checks that every fork_from names an earlier step or a
+// session an earlier step used.
+func validateForks(steps []Step) error {
+	seen := map[string]bool{}
+	for _, step := range steps {
+		if step.ForkFrom != "" && !seen[step.ForkFrom] {
+			return fmt.Errorf("step %q: fork_from %q is not an earlier step or session", step.Name, step.ForkFrom)
+		}
+		if step.ForkFrom != "" && step.NewSession {
+			return fmt.Errorf("step %q: fork_from and new_session are exclusive", step.Name)
+		}
+		seen[step.Name] = true
+		if step.Session != "" {
+			seen[step.Session] = true
+		} else {
+			seen[defaultSession] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hookTraceShim wraps one hook command. It runs the command unchanged and,
+// when $HARNESS_HOOK_LOG is set, appends a JSON line recording the event,
+// matcher, stdin payload, exit code, duration, and output. Durations use
+// $EPOCHREALTIME because `date` may be pinned by the scenario clock; bash
+// before 5.0 (macOS /bin/bash) lacks it, so the duration is null there.
+const hookTraceShim = `#!/bin/bash
+# hook tracing shim installed by the test harness
+EVENT=$1 MATCHER=$2 COMMAND=$3
+[ -n "$HARNESS_HOOK_LOG" ] || exec bash -c "$COMMAND"
+
+PAYLOAD=$(cat)
+OUT=$(mktemp) ERR=$(mktemp)
+START=$EPOCHREALTIME
+printf '%s' "$PAYLOAD" | bash -c "$COMMAND" >"$OUT" 2>"$ERR"
+CODE=$?
+END=$EPOCHREALTIME
+
+jq -cn --arg event "$EVENT" --arg matcher "$MATCHER" --arg command "$COMMAND" \
+  --arg payload "$PAYLOAD" --rawfile stdout "$OUT" --rawfile stderr "$ERR" \
+  --argjson exit_code "$CODE" --arg t0 "$START" --arg t1 "$END" \
+  '{event: $event, matcher: $matcher, command: $command, payload: $payload,
+    exit_code: $exit_code, stdout: $stdout, stderr: $stderr,
+    duration_ms: (if $t0 == "" or $t1 == "" then null else
+      ((($t1 | sub(","; ".") | tonumber) - ($t0 | sub(","; ".") | tonumber)) * 1000 | floor) end)}' \
+  >>"$HARNESS_HOOK_LOG" 2>/dev/null
+
+cat "$OUT"
+cat "$ERR" >&2
+rm -f "$OUT" "$ERR"
+exit $CODE
+`
+
+// hookTraceShimName is the shim's file name inside a mirrored plugin's
+// .claude-plugin directory.
+const hookTraceShimName = "hook-trace.sh"
+
+// HookCall is one traced hook invocation.
+type HookCall struct {
+	Event      string `json:"event"`
+	Matcher    string `json:"matcher"`
+	Command    string `json:"command"`
+	Payload    string `json:"payload"`
+	ExitCode   int    `json:"exit_code"`
+	DurationMS *int64 `json:"duration_ms"` // nil when bash can't time hooks
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+}
+
+// Name is the hook script's base name, e.g. "code-gate.sh".
+func (h HookCall) Name() string {
+	fields := strings.Fields(h.Command)
+	if len(fields) == 0 {
+		return ""
+	}
+	return filepath.Base(strings.Trim(fields[0], `"'`))
+}
+
+// Duration formats DurationMS for display.
+func (h HookCall) Duration() string {
+	if h.DurationMS == nil {
+		return "?ms"
+	}
+	return fmt.Sprintf("%dms", *h.DurationMS)
+}
+
+// Matches reports whether name refers to this call: the script name with or
+// without its extension, or the hook event.
+func (h HookCall) Matches(name string) bool {
+	base := h.Name()
+	return name == base || name == strings.TrimSuffix(base, filepath.Ext(base)) || name == h.Event
+}
+
+// mirrorPlugin builds dst as a copy of the plugin at src in which every
+// top-level entry is a symlink back to src, except .claude-plugin, whose
+// plugin.json gets each hook command wrapped in the tracing shim, and a
+// hooks directory holding hooks.json, which is wrapped the same way. Scripts
+// still resolve through the symlinks, so hooks behave as in the real plugin.
+func mirrorPlugin(src, dst string) error {
+	if err := os.MkdirAll(filepath.Join(dst, ".claude-plugin"), 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == ".claude-plugin" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(src, e.Name(), "hooks.json")); err == nil && e.Name() == "hooks" {
+			if err := mirrorHooksDir(filepath.Join(src, "hooks"), filepath.Join(dst, "hooks")); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Symlink(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	manifestDir := filepath.Join(src, ".claude-plugin")
+	entries, err = os.ReadDir(manifestDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == "plugin.json" {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(manifestDir, e.Name()), filepath.Join(dst, ".claude-plugin", e.Name())); err != nil {
+			return err
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(manifestDir, "plugin.json"))
+	if err != nil {
+		return err
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	switch hooks := manifest["hooks"].(type) {
+	case nil:
+	case map[string]interface{}:
+		wrapHookCommands(hooks)
+	default:
+		// Hook files named in plugin.json would run untraced
+		return fmt.Errorf("plugin.json hooks %v: only inline hooks and hooks/hooks.json can be traced", hooks)
+	}
+	wrapped, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dst, ".claude-plugin", "plugin.json"), wrapped, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dst, ".claude-plugin", hookTraceShimName), []byte(hookTraceShim), 0755)
+}
+
+// mirrorHooksDir mirrors a plugin's hooks directory with every entry a
+// symlink back to src except hooks.json, whose commands are wrapped.
+func mirrorHooksDir(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == "hooks.json" {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(src, "hooks.json"))
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing %s: %w", filepath.Join(src, "hooks.json"), err)
+	}
+	if hooks, ok := doc["hooks"].(map[string]interface{}); ok {
+		wrapHookCommands(hooks)
+	}
+	wrapped, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dst, "hooks.json"), wrapped, 0644)
+}
+
+// wrapHookCommands rewrites the command hooks of a plugin.json "hooks"
+// object in place to run through the tracing shim.
+func wrapHookCommands(hooks map[string]interface{}) {
+	shim := `"${CLAUDE_PLUGIN_ROOT}/.claude-plugin/` + hookTraceShimName + `"`
+	for event, groups := range hooks {
+		groupList, _ := groups.([]interface{})
+		for _, g := range groupList {
+			group, _ := g.(map[string]interface{})
+			matcher, _ := group["matcher"].(string)
+			handlers, _ := group["hooks"].([]interface{})
+			for _, h := range handlers {
+				handler, _ := h.(map[string]interface{})
+				command, _ := handler["command"].(string)
+				if handler["type"] != "command" || command == "" {
+					continue
+				}
+				handler["command"] = strings.Join([]string{
+					shim, shellQuote(event), shellQuote(matcher), shellQuote(command),
+				}, " ")
+			}
+		}
+	}
+}
+
+// shellQuote single-quotes s for bash.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// readHookTrace parses a step's hook log. A missing log means no hooks ran.
+func readHookTrace(path string) ([]HookCall, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var calls []HookCall
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var call HookCall
+		if err := json.Unmarshal([]byte(line), &call); err != nil {
+			return calls, fmt.Errorf("hook trace %s: %w", path, err)
+		}
+		calls = append(calls, call)
+	}
+	return calls, scanner.Err()
+}
+
+// sessionPluginDirs returns the plugin directories a session loads: the
+// project's local plugins when linked, otherwise each plugin listed in the
+// marketplace catalog at pluginDir. A directory without a catalog is
+// assumed to be a plugin itself.
+func sessionPluginDirs(project *TestProject, pluginDir string) []string {
+	if project != nil && len(project.Plugins) > 0 {
+		return sortedValues(project.Plugins)
+	}
+	if pluginDir == "" {
+		return nil
+	}
+	_, entries, err := readCatalog(pluginDir)
+	if err != nil {
+		return []string{pluginDir}
+	}
+	var dirs []string
+	for _, entry := range entries {
+		dirs = append(dirs, filepath.Join(pluginDir, entry.Source))
+	}
+	return dirs
+}
+
+// sortedValues returns a map's values ordered by key.
+func sortedValues(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = m[k]
+	}
+	return values
+}
+
+// checkHookAssertion evaluates hook_invoked and hook_order against the
+// hooks traced during the step.
+func checkHookAssertion(a Assertion, calls []HookCall, traced bool) (bool, string) {
+	if !traced {
+		return false, "hooks are only traced in projects with plugin_link"
+	}
+	var seen []string
+	for _, call := range calls {
+		seen = append(seen, call.Event+":"+call.Name())
+	}
+	have := strings.Join(seen, ", ")
+	if have == "" {
+		have = "none"
+	}
+
+	switch a.Type {
+	case "hook_invoked":
+		count := 0
+		for _, call := range calls {
+			if call.Matches(a.Value) {
+				count++
+			}
+		}
+		if a.Times != nil {
+			if count != *a.Times {
+				return false, fmt.Sprintf("hook %q ran %d times, expected %d (ran: %s)", a.Value, count, *a.Times, have)
+			}
+		} else if count == 0 {
+			return false, fmt.Sprintf("hook %q did not run (ran: %s)", a.Value, have)
+		}
+
+	case "hook_order":
+		// The listed hooks must run in this order, other hooks may interleave
+		next := 0
+		for _, call := range calls {
+			if next < len(a.Paths) && call.Matches(a.Paths[next]) {
+				next++
+			}
+		}
+		if next < len(a.Paths) {
+			return false, fmt.Sprintf("hooks did not run in order %s; %q missing after %d matched (ran: %s)",
+				strings.Join(a.Paths, " -> "), a.Paths[next], next, have)
+		}
+	}
+	return true, ""
+}
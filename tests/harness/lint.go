@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+func cmdLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: test-harness lint <scenario.yaml|dir|glob|scenarios.list> ...\n")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	paths, err := discoverScenarios(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	bad := 0
+	for _, path := range paths {
+		problems := lintScenario(path)
+		if len(problems) == 0 {
+			continue
+		}
+		bad++
+		fmt.Printf("%s:\n", path)
+		for _, p := range problems {
+			fmt.Printf("  - %s\n", p)
+		}
+	}
+	fmt.Printf("%d scenarios, %d with problems\n", len(paths), bad)
+	if bad > 0 {
+		return 1
+	}
+	return 0
+}
+
+// lintScenario reports what loadScenario rejects, plus unknown fields,
+// steps that are not exactly one of prompt or run, and unknown or
+// incomplete assertions.
+func lintScenario(path string) []string {
+	scenario, err := loadScenario(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	data, _ := os.ReadFile(path)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&Scenario{}); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	switch scenario.Type {
+	case "", "unit", "integration":
+	default:
+		problems = append(problems, fmt.Sprintf("type %q must be unit or integration", scenario.Type))
+	}
+	if len(scenario.Steps) == 0 {
+		problems = append(problems, "no steps")
+	}
+
+	names := map[string]bool{}
+	for i, step := range scenario.Steps {
+		where := fmt.Sprintf("step %d", i+1)
+		if step.Name == "" {
+			problems = append(problems, where+": no name")
+		} else {
+			where = fmt.Sprintf("step %q", step.Name)
+			if names[step.Name] {
+				problems = append(problems, where+": duplicate name")
+			}
+			names[step.Name] = true
+		}
+		if (step.Prompt == "") == (step.Run == "") {
+			problems = append(problems, where+": needs exactly one of prompt or run")
+		}
+		for _, a := range step.Assertions {
+			kind, ok := assertionKinds[a.Type]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("%s: unknown assertion type %q", where, a.Type))
+			case kind.needsPath && a.Path == "":
+				problems = append(problems, fmt.Sprintf("%s: %s needs a path", where, a.Type))
+			}
+		}
+	}
+	return problems
+}
@@ -4,31 +4,91 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// commands are the harness subcommands; each returns the exit code.
+var commands = map[string]func(args []string) int{
+	"run":    cmdRun,
+	"list":   cmdList,
+	"lint":   cmdLint,
+	"report": cmdReport,
+	"new":    cmdNew,
+}
+
 func main() {
-	pluginDir := flag.String("plugin-dir", "", "Path to marketplace plugin directory (default: auto-detect)")
-	workDir := flag.String("work-dir", "", "Working directory (default: temp dir per scenario)")
-	keep := flag.Bool("keep", false, "Don't clean up work dir after tests")
-	unitOnly := flag.Bool("unit-only", false, "Skip steps that call claude (run only 'run' steps)")
-	integrationOnly := flag.Bool("integration-only", false, "Run only integration scenarios")
-	verbose := flag.Bool("verbose", false, "Show full command/claude output")
-	timeout := flag.Duration("timeout", 2*time.Minute, "Per-step timeout")
-
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: test-harness [flags] <scenario.yaml> [scenario2.yaml ...]\n\nFlags:\n")
-		flag.PrintDefaults()
-	}
-	flag.Parse()
-
-	args := flag.Args()
-	if len(args) == 0 {
-		flag.Usage()
+	if len(os.Args) == 1 {
+		usage()
 		os.Exit(1)
 	}
+	if cmd, ok := commands[os.Args[1]]; ok {
+		os.Exit(cmd(os.Args[2:]))
+	}
+	if os.Args[1] == "help" {
+		usage()
+		return
+	}
+	// Without a subcommand the arguments are run's, as run-tests.sh passes them
+	os.Exit(cmdRun(os.Args[1:]))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: test-harness <command> [flags] [args]
+
+Commands:
+  run     Run scenarios (the default when no command is given)
+  list    Print the scenarios that would run, with type and tags
+  lint    Check scenarios for errors without running them
+  report  Re-render a results file written by run -results
+  new     Scaffold a unit scenario for a plugin script
+
+Run "test-harness <command> -h" for a command's flags.
+`)
+}
+
+func cmdRun(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	pluginDir := fs.String("plugin-dir", "", "Path to marketplace plugin directory (default: auto-detect)")
+	workDir := fs.String("work-dir", "", "Working directory (default: temp dir per scenario)")
+	keep := fs.Bool("keep", false, "Don't clean up work dir after tests")
+	unitOnly := fs.Bool("unit-only", false, "Skip steps that call claude (run only 'run' steps)")
+	integrationOnly := fs.Bool("integration-only", false, "Run only integration scenarios")
+	verbose := fs.Bool("verbose", false, "Show full command/claude output")
+	timeout := fs.Duration("timeout", 2*time.Minute, "Per-step timeout")
+	hermetic := fs.Bool("hermetic", false, "Run every scenario in a hermetic environment (temp HOME, no git config, minimal PATH)")
+	passEnv := fs.String("pass-env", "", "Comma-separated host variables to pass through in hermetic mode")
+	promptTimeout := fs.Duration("prompt-timeout", defaultPromptTimeout, "Per-prompt timeout for claude (steps can override with timeout:)")
+	pauseOnFailure := fs.Bool("pause-on-failure", false, "After a failing step, open a shell in its work dir, then continue, retry, or abort")
+	artifactsDir := fs.String("artifacts-dir", "", "Save each step's claude output, transcript, and run output under this directory")
+	keepOnFailure := fs.Bool("keep-on-failure", false, "Bundle a failing scenario's project, remote, and plugins as a tar.gz in the artifacts dir (default dir: test-artifacts)")
+	resultsPath := fs.String("results", "", "Write the run's results as JSON to this file")
+	baselinePath := fs.String("baseline", "", "Compare results against a JSON file written by -results")
+	failOn := fs.String("fail-on", "any", "Exit non-zero on any failure, or only on new failures vs -baseline (any|regressions)")
+	noTemplateCache := fs.Bool("no-template-cache", false, "Provision every project from scratch instead of copying cached templates")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: test-harness [run] [flags] <scenario.yaml|dir|glob|scenarios.list> ...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	paths, err := discoverScenarios(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	baseline, err := loadBaseline(*baselinePath, *failOn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
 
 	opts := Options{
 		PluginDir:       *pluginDir,
@@ -38,54 +98,207 @@ func main() {
 		IntegrationOnly: *integrationOnly,
 		Verbose:         *verbose,
 		Timeout:         *timeout,
+		Hermetic:        *hermetic,
+		ArtifactsDir:    *artifactsDir,
+		PromptTimeout:   *promptTimeout,
+		PauseOnFailure:  *pauseOnFailure,
+		KeepOnFailure:   *keepOnFailure,
+		ScenarioRoot:    commonDir(paths),
+	}
+	if opts.KeepOnFailure {
+		if opts.ArtifactsDir == "" {
+			opts.ArtifactsDir = "test-artifacts"
+		}
+		opts.RerunCommand = rerunCommand(fs)
+	}
+	if *passEnv != "" {
+		opts.PassEnv = strings.Split(*passEnv, ",")
+	}
+	if !*noTemplateCache {
+		opts.Templates = NewTemplateCache()
 	}
 
-	totalPass := 0
-	totalFail := 0
-	var failedScenarios []string
-
-	for _, path := range args {
+	var run ResultsFile
+	for _, path := range paths {
 		scenario, err := loadScenario(path)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading %s: %v\n", path, err)
-			os.Exit(1)
+			return 1
 		}
 
 		fmt.Printf("\n--- %s ---\n", scenario.Name)
 		report := RunScenario(scenario, opts)
+		run.Add(scenario, report)
+		printScenario(run.Scenarios[len(run.Scenarios)-1], opts.Verbose)
+	}
 
-		pass, fail := 0, 0
-		for _, r := range report.Results {
-			if r.Pass {
-				pass++
-				fmt.Printf("  PASS  %s: %s\n", r.StepName, assertionSummary(r.Assertion))
-			} else {
-				fail++
-				fmt.Printf("  FAIL  %s: %s\n", r.StepName, assertionSummary(r.Assertion))
-				if r.Detail != "" {
-					fmt.Printf("        %s\n", r.Detail)
-				}
-			}
+	if opts.Templates != nil && !opts.Keep {
+		opts.Templates.Cleanup()
+	}
+
+	fmt.Printf("\n=== Summary: %d passed, %d failed ===\n", run.Passed, run.Failed)
+	if t := opts.Templates; t != nil && t.Built+t.Hits > 0 {
+		fmt.Printf("Project templates: %d built, %d cache hits\n", t.Built, t.Hits)
+	}
+	printFailedScenarios(&run)
+
+	if *resultsPath != "" {
+		if err := run.Write(*resultsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+			return 1
 		}
+	}
+	return exitCode(&run, baseline, *baselinePath, *failOn)
+}
+
+func cmdList(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	unitOnly := fs.Bool("unit-only", false, "Leave out integration scenarios")
+	integrationOnly := fs.Bool("integration-only", false, "List only integration scenarios")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: test-harness list [flags] <scenario.yaml|dir|glob|scenarios.list> ...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
 
-		fmt.Printf("  Result: %d passed, %d failed\n", pass, fail)
-		totalPass += pass
-		totalFail += fail
-		if fail > 0 {
-			failedScenarios = append(failedScenarios, scenario.Name)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	paths, err := discoverScenarios(fs.Args())
+	if err == nil {
+		err = listScenarios(paths, *unitOnly, *integrationOnly)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func cmdReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	verbose := fs.Bool("verbose", false, "Show passing preflight runs and artifact directories of passing scenarios too")
+	baselinePath := fs.String("baseline", "", "Compare the results against another results file")
+	failOn := fs.String("fail-on", "any", "Exit non-zero on any failure, or only on new failures vs -baseline (any|regressions)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: test-harness report [flags] <results.json>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	run, err := readResults(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	baseline, err := loadBaseline(*baselinePath, *failOn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	for _, s := range run.Scenarios {
+		fmt.Printf("\n--- %s ---\n", s.Name)
+		printScenario(s, *verbose)
+	}
+	fmt.Printf("\n=== Summary: %d passed, %d failed ===\n", run.Passed, run.Failed)
+	printFailedScenarios(run)
+	return exitCode(run, baseline, *baselinePath, *failOn)
+}
+
+// printScenario prints one scenario's results as run reports them.
+func printScenario(s ScenarioRecord, verbose bool) {
+	if p := s.Preflight; p != nil && (verbose || p.ExitCode != 0) {
+		fmt.Printf("  Preflight: exit %d\n", p.ExitCode)
+		if p.ExitCode != 0 {
+			fmt.Printf("        %s\n", strings.ReplaceAll(strings.TrimSpace(p.Output), "\n", "\n        "))
+		}
+	}
+	pass, fail := 0, 0
+	for _, r := range s.Results {
+		if r.Pass {
+			pass++
+			fmt.Printf("  PASS  %s: %s%s\n", r.Step, r.Assertion, attemptsNote(r.Attempts))
+		} else {
+			fail++
+			fmt.Printf("  FAIL  %s: %s%s\n", r.Step, r.Assertion, attemptsNote(r.Attempts))
+			if r.Detail != "" {
+				fmt.Printf("        %s\n", strings.ReplaceAll(r.Detail, "\n", "\n        "))
+			}
+			if r.Artifacts != "" {
+				fmt.Printf("        artifacts: %s\n", r.Artifacts)
+			}
 		}
 	}
 
-	fmt.Printf("\n=== Summary: %d passed, %d failed ===\n", totalPass, totalFail)
-	if len(failedScenarios) > 0 {
+	if s.Error != "" {
+		fmt.Printf("  ERROR %s\n", s.Error)
+	}
+
+	fmt.Printf("  Result: %d passed, %d failed\n", pass, fail)
+	if s.Artifacts != "" && (fail > 0 || verbose) {
+		fmt.Printf("  Artifacts: %s\n", s.Artifacts)
+	}
+	if s.Bundle != "" {
+		fmt.Printf("  Bundle: %s\n", s.Bundle)
+	}
+}
+
+func printFailedScenarios(f *ResultsFile) {
+	failed := f.FailedScenarios()
+	if len(failed) > 0 {
 		fmt.Printf("Failed scenarios:\n")
-		for _, name := range failedScenarios {
+		for _, name := range failed {
 			fmt.Printf("  - %s\n", name)
 		}
-		os.Exit(1)
 	}
 }
 
+// loadBaseline checks -fail-on and reads the -baseline file, if any.
+func loadBaseline(path, failOn string) (*ResultsFile, error) {
+	switch failOn {
+	case "any":
+	case "regressions":
+		if path == "" {
+			return nil, fmt.Errorf("-fail-on=regressions requires -baseline")
+		}
+	default:
+		return nil, fmt.Errorf("-fail-on %q must be any or regressions", failOn)
+	}
+	if path == "" {
+		return nil, nil
+	}
+	baseline, err := readResults(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading baseline: %w", err)
+	}
+	return baseline, nil
+}
+
+// exitCode prints the baseline comparison, if any, and returns 1 when the
+// run failed: on any failure, or only on regressions with -fail-on.
+func exitCode(run, baseline *ResultsFile, baselinePath, failOn string) int {
+	if baseline != nil {
+		comparison := compareResults(baseline, run)
+		comparison.Print(os.Stdout, baselinePath)
+		if failOn == "regressions" {
+			if comparison.Regressions() > 0 {
+				return 1
+			}
+			return 0
+		}
+	}
+	if len(run.FailedScenarios()) > 0 {
+		return 1
+	}
+	return 0
+}
+
 func loadScenario(path string) (Scenario, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -100,10 +313,114 @@ func loadScenario(path string) (Scenario, error) {
 	if scenario.Name == "" {
 		scenario.Name = path
 	}
+	scenario.Path = path
+
+	switch scenario.OnSessionError {
+	case "", "fail", "retry", "continue":
+	default:
+		return Scenario{}, fmt.Errorf("on_session_error %q must be fail, retry, or continue", scenario.OnSessionError)
+	}
+	if scenario.Project != nil {
+		switch scenario.Project.Preflight {
+		case "", "required", "optional", "skip":
+		default:
+			return Scenario{}, fmt.Errorf("project.preflight %q must be required, optional, or skip", scenario.Project.Preflight)
+		}
+	}
+	if scenario.Now != "" {
+		if _, err := time.Parse(time.RFC3339, scenario.Now); err != nil {
+			return Scenario{}, fmt.Errorf("now: %w", err)
+		}
+	}
+	if err := scenario.SessionRetry.Validate(); err != nil {
+		return Scenario{}, err
+	}
+	if err := validateForks(scenario.Steps); err != nil {
+		return Scenario{}, err
+	}
+	for _, step := range scenario.Steps {
+		if err := step.sendOptions("", "").Validate(); err != nil {
+			return Scenario{}, fmt.Errorf("step %q: %w", step.Name, err)
+		}
+		if step.Timeout != "" {
+			if _, err := time.ParseDuration(step.Timeout); err != nil {
+				return Scenario{}, fmt.Errorf("step %q: timeout: %w", step.Name, err)
+			}
+		}
+		if step.AdvanceClock != "" {
+			if _, err := parseClockDuration(step.AdvanceClock); err != nil {
+				return Scenario{}, fmt.Errorf("step %q: advance_clock: %w", step.Name, err)
+			}
+			if scenario.Now == "" {
+				return Scenario{}, fmt.Errorf("step %q: advance_clock needs a pinned clock (set now:)", step.Name)
+			}
+		}
+	}
 
 	return scenario, nil
 }
 
+// listScenarios prints the scenarios a run would execute, leaving out
+// those -unit-only or -integration-only would skip.
+func listScenarios(paths []string, unitOnly, integrationOnly bool) error {
+	for _, path := range paths {
+		scenario, err := loadScenario(path)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", path, err)
+		}
+		kind := scenario.Type
+		if kind == "" {
+			kind = "unit"
+		}
+		if (unitOnly && kind == "integration") || (integrationOnly && kind != "integration") {
+			continue
+		}
+		tags := ""
+		if len(scenario.Tags) > 0 {
+			tags = "  [" + strings.Join(scenario.Tags, ", ") + "]"
+		}
+		fmt.Printf("%-11s  %s%s\n", kind, path, tags)
+	}
+	return nil
+}
+
+// rerunFlagsDropped are run flags that make no sense when rerunning one
+// scenario from a bundle: they compare, record, or pause the whole run, or
+// would empty the artifact dir holding the bundle being debugged.
+var rerunFlagsDropped = map[string]bool{
+	"results": true, "baseline": true, "fail-on": true, "pause-on-failure": true,
+	"artifacts-dir": true, "keep-on-failure": true,
+}
+
+// rerunCommand rebuilds this run invocation from the flags that were set,
+// without its scenario arguments, so a bundle manifest can name the
+// command that reruns one scenario.
+func rerunCommand(fs *flag.FlagSet) string {
+	words := []string{"cd", shellQuote(mustGetwd()), "&&", shellQuote(os.Args[0]), "run"}
+	fs.Visit(func(f *flag.Flag) {
+		if !rerunFlagsDropped[f.Name] {
+			words = append(words, shellQuote("-"+f.Name+"="+f.Value.String()))
+		}
+	})
+	return strings.Join(words, " ")
+}
+
+func mustGetwd() string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return wd
+}
+
+// attemptsNote flags results from prompts that needed retries.
+func attemptsNote(attempts int) string {
+	if attempts > 1 {
+		return fmt.Sprintf(" [%d attempts]", attempts)
+	}
+	return ""
+}
+
 func assertionSummary(a Assertion) string {
 	neg := ""
 	if a.Negate {
@@ -136,6 +453,33 @@ func assertionSummary(a Assertion) string {
 		return fmt.Sprintf("symlink_exists(%s)%s", a.Path, neg)
 	case "config_value":
 		return fmt.Sprintf("config_value(%s, %q)%s", a.Path, a.Value, neg)
+	case "fork_from":
+		return fmt.Sprintf("fork_from(%s)%s", a.Value, neg)
+	case "in_worktree":
+		return fmt.Sprintf("in_worktree(%s)%s", a.Value, neg)
+	case "advance_clock":
+		return fmt.Sprintf("advance_clock(%s)%s", a.Value, neg)
+	case "worktree_exists":
+		return fmt.Sprintf("worktree_exists(%s)%s", a.Path, neg)
+	case "worktree_branch":
+		return fmt.Sprintf("worktree_branch(%s, %q)%s", a.Path, a.Value, neg)
+	case "worktree_count":
+		return fmt.Sprintf("worktree_count(%s)%s", a.Value, neg)
+	case "request_contains", "system_prompt_contains":
+		return fmt.Sprintf("%s(%q)%s", a.Type, a.Value, neg)
+	case "hook_invoked":
+		if a.Times != nil {
+			return fmt.Sprintf("hook_invoked(%s, %d)%s", a.Value, *a.Times, neg)
+		}
+		return fmt.Sprintf("hook_invoked(%s)%s", a.Value, neg)
+	case "hook_order":
+		return fmt.Sprintf("hook_order(%s)%s", strings.Join(a.Paths, " -> "), neg)
+	case "session_ok":
+		return "session_ok" + neg
+	case "mock_turns":
+		return fmt.Sprintf("mock_turns(%s)", a.Value)
+	case "files_created", "files_modified", "files_deleted", "only_changed":
+		return fmt.Sprintf("%s(%s)%s", a.Type, strings.Join(assertionGlobs(a), ", "), neg)
 	default:
 		return fmt.Sprintf("%s(%s, %q)%s", a.Type, a.Path, a.Value, neg)
 	}
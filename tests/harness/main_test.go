@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestAssertionSummarySyntheticTypes(t *testing.T) {
+	cases := map[string]Assertion{
+		"fork_from(base)":                {Type: "fork_from", Value: "base"},
+		"in_worktree(feature)":           {Type: "in_worktree", Value: "feature"},
+		"advance_clock(2d)":              {Type: "advance_clock", Value: "2d"},
+		"mock_turns(consumed 1 of 3)":    {Type: "mock_turns", Value: "consumed 1 of 3"},
+		"session_ok":                     {Type: "session_ok"},
+		`file_contains(a.txt, "x")`:      {Type: "file_contains", Path: "a.txt", Value: "x"},
+		`output_contains("y") (negated)`: {Type: "output_contains", Value: "y", Negate: true},
+	}
+	for want, a := range cases {
+		if got := assertionSummary(a); got != want {
+			t.Errorf("assertionSummary(%+v) = %q, want %q", a, got, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// catalogEntry is the part of a marketplace.json plugin entry the harness needs.
+type catalogEntry struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// readCatalog loads .claude-plugin/marketplace.json from a marketplace root.
+// The raw document is returned too so unknown fields survive a rewrite.
+func readCatalog(root string) (map[string]interface{}, []catalogEntry, error) {
+	path := filepath.Join(root, ".claude-plugin", "marketplace.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading marketplace catalog: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	var parsed struct {
+		Plugins []catalogEntry `json:"plugins"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return raw, parsed.Plugins, nil
+}
+
+// linkMarketplace builds a local marketplace in dir from the real catalog
+// at realPluginDir, mirroring each selected plugin with its hooks traced
+// (see mirrorPlugin). An empty selection links every plugin. Returns
+// plugin name -> local plugin dir.
+func linkMarketplace(realPluginDir, dir string, selected []string) (map[string]string, error) {
+	raw, entries, err := readCatalog(realPluginDir)
+	if err != nil {
+		return nil, err
+	}
+
+	want := map[string]bool{}
+	for _, name := range selected {
+		want[name] = true
+	}
+
+	var keep []interface{}
+	linked := map[string]string{}
+	rawPlugins, _ := raw["plugins"].([]interface{})
+	for i, entry := range entries {
+		if len(want) > 0 && !want[entry.Name] {
+			continue
+		}
+		if entry.Source == "" || filepath.IsAbs(entry.Source) || strings.HasPrefix(filepath.Clean(entry.Source), "..") {
+			return nil, fmt.Errorf("plugin %q: source %q is not a path inside the marketplace", entry.Name, entry.Source)
+		}
+		source := filepath.Join(realPluginDir, entry.Source)
+		if err := validatePluginManifest(entry.Name, source); err != nil {
+			return nil, err
+		}
+
+		link := filepath.Join(dir, entry.Source)
+		if err := mirrorPlugin(source, link); err != nil {
+			return nil, fmt.Errorf("linking plugin %s: %w", entry.Name, err)
+		}
+		linked[entry.Name] = link
+		keep = append(keep, rawPlugins[i])
+	}
+	for _, name := range selected {
+		if _, ok := linked[name]; !ok {
+			return nil, fmt.Errorf("plugin %q is not in the marketplace catalog", name)
+		}
+	}
+
+	raw["name"] = "test-marketplace"
+	raw["plugins"] = keep
+	catalogJSON, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	marketplaceDir := filepath.Join(dir, ".claude-plugin")
+	if err := os.MkdirAll(marketplaceDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(marketplaceDir, "marketplace.json"), catalogJSON, 0644); err != nil {
+		return nil, err
+	}
+	return linked, nil
+}
+
+// validatePluginManifest checks that dir holds a parseable
+// .claude-plugin/plugin.json whose name matches the catalog entry.
+func validatePluginManifest(name, dir string) error {
+	path := filepath.Join(dir, ".claude-plugin", "plugin.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("plugin %q: %w", name, err)
+	}
+	var manifest struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("plugin %q: invalid %s: %w", name, path, err)
+	}
+	if manifest.Name != name {
+		return fmt.Errorf("plugin %q: %s declares name %q", name, path, manifest.Name)
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MockTurn is one scripted model response: optional text followed by
+// optional tool calls. A turn with tool calls stops with "tool_use", so
+// claude runs the tools (and the plugin hooks) and asks for the next turn.
+type MockTurn struct {
+	Text    string        `yaml:"text"`
+	ToolUse []MockToolUse `yaml:"tool_use"`
+}
+
+// MockToolUse is a tool_use content block, e.g. {name: Edit, input: {...}}.
+type MockToolUse struct {
+	Name  string                 `yaml:"name"`
+	Input map[string]interface{} `yaml:"input"`
+}
+
+// mockIdleText answers requests once the script is used up, ending the turn.
+const mockIdleText = "(mock: no scripted turns left)"
+
+// MockAPI is a local stand-in for the Anthropic Messages API. claude is
+// pointed at it with ANTHROPIC_BASE_URL. Requests that offer tools come
+// from the main agent loop and consume scripted turns in order; requests
+// without tools are claude's own side queries (titles, summaries) and get
+// a short fixed reply so they don't eat the script.
+type MockAPI struct {
+	server   *http.Server
+	listener net.Listener
+
+	mu       sync.Mutex
+	turns    []MockTurn
+	requests []MockRequest
+	nextID   int
+}
+
+// MockRequest is a Messages API request captured by the mock, reduced to
+// its text so assertions don't have to deal with JSON escaping.
+type MockRequest struct {
+	Body   string // raw JSON body
+	System string // system prompt, system messages, and <system-reminder> user blocks
+	Text   string // every string value in the request, newline separated
+}
+
+// StartMockAPI listens on a free loopback port.
+func StartMockAPI() (*MockAPI, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("mock API listen: %w", err)
+	}
+	m := &MockAPI{listener: ln}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/messages", m.handleMessages)
+	mux.HandleFunc("/v1/messages/count_tokens", m.handleCountTokens)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeMockError(w, http.StatusNotFound, "not_found_error", "mock API has no "+r.URL.Path)
+	})
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(ln)
+	return m, nil
+}
+
+// URL is the base URL for ANTHROPIC_BASE_URL.
+func (m *MockAPI) URL() string {
+	return "http://" + m.listener.Addr().String()
+}
+
+// Env returns the variables that point claude at the mock.
+func (m *MockAPI) Env() map[string]string {
+	return map[string]string{
+		"ANTHROPIC_BASE_URL":                       m.URL(),
+		"ANTHROPIC_API_KEY":                        "mock-api-key",
+		"CLAUDE_CODE_DISABLE_NONESSENTIAL_TRAFFIC": "1",
+	}
+}
+
+// Script replaces the queued turns and clears the captured requests.
+func (m *MockAPI) Script(turns []MockTurn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.turns = append([]MockTurn(nil), turns...)
+	m.requests = nil
+}
+
+// Requests returns the requests received since the last Script.
+func (m *MockAPI) Requests() []MockRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MockRequest(nil), m.requests...)
+}
+
+// Remaining returns how many scripted turns were not consumed.
+func (m *MockAPI) Remaining() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.turns)
+}
+
+// Close stops the server.
+func (m *MockAPI) Close() {
+	if m != nil {
+		m.server.Close()
+	}
+}
+
+// expandMockTurns applies expandVars to every string in the tool inputs so
+// scripts can name files as $WORK_DIR/foo.py.
+func expandMockTurns(turns []MockTurn, workDir, remoteDir string) []MockTurn {
+	var expand func(v interface{}) interface{}
+	expand = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case string:
+			return expandVars(v, workDir, remoteDir)
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(v))
+			for k, item := range v {
+				out[k] = expand(item)
+			}
+			return out
+		case []interface{}:
+			out := make([]interface{}, len(v))
+			for i, item := range v {
+				out[i] = expand(item)
+			}
+			return out
+		}
+		return v
+	}
+
+	out := make([]MockTurn, len(turns))
+	for i, turn := range turns {
+		out[i] = MockTurn{Text: turn.Text}
+		for _, tool := range turn.ToolUse {
+			input, _ := expand(tool.Input).(map[string]interface{})
+			out[i].ToolUse = append(out[i].ToolUse, MockToolUse{Name: tool.Name, Input: input})
+		}
+	}
+	return out
+}
+
+// mockRequest is the part of a Messages API request the mock reads.
+type mockRequest struct {
+	Model    string            `json:"model"`
+	Stream   bool              `json:"stream"`
+	Tools    []json.RawMessage `json:"tools"`
+	System   json.RawMessage   `json:"system"`
+	Messages []mockMessage     `json:"messages"`
+}
+
+// mockMessage is a conversation message; content is a string or a list of
+// content blocks.
+type mockMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+func (m *MockAPI) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMockError(w, http.StatusMethodNotAllowed, "invalid_request_error", "POST required")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeMockError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	var req mockRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeMockError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	m.capture(body, req)
+
+	turn := MockTurn{Text: "OK"}
+	if len(req.Tools) > 0 {
+		turn = m.nextTurn()
+	}
+	msg := m.message(req.Model, turn)
+	if req.Stream {
+		writeMockStream(w, msg)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+func (m *MockAPI) handleCountTokens(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"input_tokens": 1})
+}
+
+// capture records a request. The system prompt is either a string or a
+// list of text blocks. claude sends CLAUDE.md and .claude/rules as
+// <system-reminder> text in user messages, and newer versions send
+// SessionStart hook output as role "system" messages, so both count as
+// system prompt too.
+func (m *MockAPI) capture(body []byte, req mockRequest) {
+	captured := MockRequest{Body: string(body)}
+
+	var system interface{}
+	if len(req.System) > 0 && json.Unmarshal(req.System, &system) == nil {
+		captured.System = strings.Join(collectStrings(system, "text"), "\n")
+	}
+	for _, msg := range req.Messages {
+		var content interface{}
+		if msg.Role == "assistant" || json.Unmarshal(msg.Content, &content) != nil {
+			continue
+		}
+		for _, text := range collectStrings(content, "text") {
+			if msg.Role == "system" || strings.Contains(text, "<system-reminder>") {
+				captured.System += "\n" + text
+			}
+		}
+	}
+	var doc interface{}
+	if json.Unmarshal(body, &doc) == nil {
+		captured.Text = strings.Join(collectStrings(doc, ""), "\n")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, captured)
+}
+
+// collectStrings walks decoded JSON and returns its string values, with
+// object keys visited in sorted order. A non-empty key limits the walk to
+// values under that key (or bare strings, for a plain-string system prompt).
+func collectStrings(v interface{}, key string) []string {
+	var out []string
+	var walk func(v interface{}, keep bool)
+	walk = func(v interface{}, keep bool) {
+		switch v := v.(type) {
+		case string:
+			if keep {
+				out = append(out, v)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item, keep)
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(v[k], keep || k == key)
+			}
+		}
+	}
+	walk(v, key == "")
+	if key != "" {
+		if s, ok := v.(string); ok {
+			return []string{s}
+		}
+	}
+	return out
+}
+
+// nextTurn pops the next scripted turn, or an idle reply when none are left.
+func (m *MockAPI) nextTurn() MockTurn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.turns) == 0 {
+		return MockTurn{Text: mockIdleText}
+	}
+	turn := m.turns[0]
+	m.turns = m.turns[1:]
+	return turn
+}
+
+// message builds a Messages API response for a turn.
+func (m *MockAPI) message(model string, turn MockTurn) map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+
+	var content []map[string]interface{}
+	if turn.Text != "" {
+		content = append(content, map[string]interface{}{"type": "text", "text": turn.Text})
+	}
+	for i, tool := range turn.ToolUse {
+		input := tool.Input
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    fmt.Sprintf("toolu_mock_%03d_%d", m.nextID, i),
+			"name":  tool.Name,
+			"input": input,
+		})
+	}
+	if content == nil {
+		content = []map[string]interface{}{{"type": "text", "text": ""}}
+	}
+
+	stopReason := "end_turn"
+	if len(turn.ToolUse) > 0 {
+		stopReason = "tool_use"
+	}
+	if model == "" {
+		model = "mock-model"
+	}
+	return map[string]interface{}{
+		"id":            fmt.Sprintf("msg_mock_%03d", m.nextID),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage":         map[string]int{"input_tokens": 1, "output_tokens": 1},
+	}
+}
+
+// writeMockStream sends msg as the server-sent event sequence the
+// streaming Messages API produces.
+func writeMockStream(w http.ResponseWriter, msg map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	send := func(event string, data interface{}) {
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	start := map[string]interface{}{}
+	for k, v := range msg {
+		start[k] = v
+	}
+	start["content"] = []interface{}{}
+	start["stop_reason"] = nil
+	send("message_start", map[string]interface{}{"type": "message_start", "message": start})
+
+	for i, block := range msg["content"].([]map[string]interface{}) {
+		var empty, delta map[string]interface{}
+		switch block["type"] {
+		case "tool_use":
+			empty = map[string]interface{}{"type": "tool_use", "id": block["id"], "name": block["name"], "input": map[string]interface{}{}}
+			input, _ := json.Marshal(block["input"])
+			delta = map[string]interface{}{"type": "input_json_delta", "partial_json": string(input)}
+		default:
+			empty = map[string]interface{}{"type": "text", "text": ""}
+			delta = map[string]interface{}{"type": "text_delta", "text": block["text"]}
+		}
+		send("content_block_start", map[string]interface{}{"type": "content_block_start", "index": i, "content_block": empty})
+		send("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": i, "delta": delta})
+		send("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": i})
+	}
+
+	send("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": msg["stop_reason"], "stop_sequence": nil},
+		"usage": map[string]int{"output_tokens": 1},
+	})
+	send("message_stop", map[string]interface{}{"type": "message_stop"})
+}
+
+func writeMockError(w http.ResponseWriter, status int, typ, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"type": typ, "message": strings.TrimSpace(message)},
+	})
+}
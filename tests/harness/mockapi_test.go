@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// postMessages sends one Messages API request to the mock.
+func postMessages(t *testing.T, m *MockAPI, req map[string]interface{}) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(m.URL()+"/v1/messages", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+}
+
+func TestMockAPICapturesSystemContext(t *testing.T) {
+	m, err := StartMockAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	postMessages(t, m, map[string]interface{}{
+		"model":  "claude-test",
+		"system": []map[string]string{{"type": "text", "text": "base prompt"}},
+		"messages": []map[string]interface{}{
+			{"role": "system", "content": "SessionStart hook: yf preflight ok"},
+			{"role": "system", "content": []map[string]string{{"type": "text", "text": "session-recall: 2 tasks"}}},
+			{"role": "user", "content": []map[string]string{
+				{"type": "text", "text": "<system-reminder>CLAUDE.md rules</system-reminder>"},
+				{"type": "text", "text": "user question"},
+			}},
+			{"role": "assistant", "content": "assistant reply <system-reminder>echoed</system-reminder>"},
+		},
+	})
+
+	requests := m.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("captured %d requests, want 1", len(requests))
+	}
+	system := requests[0].System
+	for _, want := range []string{"base prompt", "yf preflight ok", "session-recall: 2 tasks", "CLAUDE.md rules"} {
+		if !strings.Contains(system, want) {
+			t.Errorf("system %q lacks %q", system, want)
+		}
+	}
+	for _, unwanted := range []string{"user question", "assistant reply"} {
+		if strings.Contains(system, unwanted) {
+			t.Errorf("system %q includes %q", system, unwanted)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Choices offered after a paused step.
+const (
+	pauseContinue = "continue"
+	pauseRetry    = "retry"
+	pauseAbort    = "abort"
+)
+
+// pauseOnFailure stops after a failing step, prints where it ran, and
+// opens an interactive $SHELL in the step's directory with the environment
+// runShell gives run steps. When the shell exits it asks whether to
+// continue with the next step, retry this one, or abort the scenario.
+func pauseOnFailure(step Step, failed []StepResult, env *Environ, dir, remoteDir, pluginDir string, extraEnv map[string]string) string {
+	vars := stepVars(dir, pluginDir, extraEnv)
+	if env.Hermetic {
+		vars = append(vars, "HOME="+env.Home)
+	}
+	sort.Strings(vars)
+
+	fmt.Printf("\n  ** Paused after failing step %q\n", step.Name)
+	for _, r := range failed {
+		if !r.Pass {
+			fmt.Printf("     FAIL %s", assertionSummary(r.Assertion))
+			if r.Detail != "" {
+				fmt.Printf(": %s", strings.SplitN(r.Detail, "\n", 2)[0])
+			}
+			fmt.Println()
+		}
+	}
+	fmt.Printf("     Work dir:   %s\n", dir)
+	if remoteDir != "" {
+		fmt.Printf("     Remote dir: %s\n", remoteDir)
+	}
+	fmt.Printf("     Env:\n")
+	for _, v := range vars {
+		fmt.Printf("       %s\n", v)
+	}
+
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/bash"
+	}
+	fmt.Printf("\n  Opening %s; exit it to choose how to go on.\n", shell)
+	cmd := exec.Command(shell)
+	cmd.Dir = dir
+	cmd.Env = append(append(env.Vars(), vars...), "HARNESS_PAUSED_STEP="+step.Name)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("  (shell exited: %v)\n", err)
+	}
+
+	for {
+		fmt.Printf("  [c]ontinue, [r]etry step, or [a]bort? ")
+		line, err := readLine(os.Stdin)
+		if err != nil {
+			fmt.Println()
+			return pauseContinue
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "c", "continue", "":
+			return pauseContinue
+		case "r", "retry":
+			return pauseRetry
+		case "a", "abort":
+			return pauseAbort
+		}
+	}
+}
+
+// readLine reads up to a newline one byte at a time, so nothing past the
+// answer is buffered away from the next shell.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
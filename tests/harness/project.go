@@ -1,32 +1,88 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 // TestProject holds paths for a provisioned test project.
 type TestProject struct {
-	BaseDir   string // temp root
-	RemoteDir string // bare git remote
-	WorkDir   string // cloned working copy
-	PluginDir string // local marketplace with symlinked plugin
+	BaseDir       string            // temp root
+	RemoteDir     string            // bare git remote
+	WorkDir       string            // cloned working copy
+	PluginDir     string            // local marketplace mirroring the real plugins
+	DefaultBranch string            // branch holding the initial commit
+	StoreIDs      map[string]string // yf_store key -> assigned ID
+	Preflight     *PreflightResult  // nil when preflight did not run
+	Worktrees     map[string]string // worktree name -> absolute path
+	Plugins       map[string]string // linked plugin name -> plugin dir in the local marketplace
+}
+
+// PreflightResult records the plugin-preflight.sh run during provisioning.
+type PreflightResult struct {
+	Output   string `json:"output"`
+	ExitCode int    `json:"exit_code"`
 }
 
 // ProjectConfig controls what gets provisioned.
 type ProjectConfig struct {
-	Git        bool              `yaml:"git"`
-	YfEnabled  bool              `yaml:"yf_enabled"`
-	PluginLink bool              `yaml:"plugin_link"`
-	Files      map[string]string `yaml:"files"`
+	Git        bool                `yaml:"git"`
+	YfEnabled  bool                `yaml:"yf_enabled"`
+	PluginLink bool                `yaml:"plugin_link"`
+	Plugins    []string            `yaml:"plugins"` // catalog plugins to link (default: all)
+	Files      map[string]FileSpec `yaml:"files"`
+	Fixtures   []string            `yaml:"fixtures"` // directories copied into the project from the nearest fixtures dir above the scenario
+	Commits    []CommitSpec        `yaml:"commits"`  // history seeded after the initial commit
+	Branches   []BranchSpec        `yaml:"branches"` // created after commits
+	Tags       []TagSpec           `yaml:"tags"`     // created after branches
+	Checkout   string              `yaml:"checkout"` // branch left checked out (default: initial branch)
+	Push       bool                `yaml:"push"`     // push all branches and tags to the remote
+	Worktrees  []WorktreeSpec      `yaml:"worktrees"`
+	YfStore    []StoreEntity       `yaml:"yf_store"` // entities seeded into .yoshiko-flow
+
+	// Preflight controls plugin-preflight.sh when yf_enabled is set:
+	// "required" fails provisioning on a non-zero exit, "optional" (default)
+	// records the result, and "skip" doesn't run it.
+	Preflight           string      `yaml:"preflight"`
+	PreflightAssertions []Assertion `yaml:"preflight_assertions"`
+
+	// FixtureRoot is the directory fixtures are looked up in; set by the runner.
+	FixtureRoot string `yaml:"-"`
+
+	// YfConfig is deep-merged over the default config.json written for
+	// yf_enabled and validated against the keys yf-config.sh understands.
+	YfConfig map[string]interface{} `yaml:"yf_config"`
+}
+
+// CommitSpec describes one seeded commit.
+type CommitSpec struct {
+	Message string              `yaml:"message"`
+	Files   map[string]FileSpec `yaml:"files"`
+	Author  string              `yaml:"author"` // "Name <email>" (default: Test User)
+	Date    string              `yaml:"date"`   // any format git accepts; sets author and committer date
+	Branch  string              `yaml:"branch"` // created from the current HEAD if missing
+}
+
+// BranchSpec describes a branch created without new commits.
+type BranchSpec struct {
+	Name string `yaml:"name"`
+	From string `yaml:"from"` // start point (default: HEAD)
+}
+
+// TagSpec describes a tag. A message makes it annotated.
+type TagSpec struct {
+	Name    string `yaml:"name"`
+	Ref     string `yaml:"ref"` // default: HEAD
+	Message string `yaml:"message"`
 }
 
 // ProvisionProject creates a self-contained test environment:
 // bare remote, cloned working copy, optional yf config.
-func ProvisionProject(realPluginDir string, cfg ProjectConfig) (*TestProject, error) {
+func ProvisionProject(realPluginDir string, cfg ProjectConfig, env *Environ) (*TestProject, error) {
 	baseDir, err := os.MkdirTemp("", "test-project-*")
 	if err != nil {
 		return nil, fmt.Errorf("mkdirTemp: %w", err)
@@ -36,34 +92,37 @@ func ProvisionProject(realPluginDir string, cfg ProjectConfig) (*TestProject, er
 
 	// Step 1: Create bare remote
 	project.RemoteDir = filepath.Join(baseDir, "remote.git")
-	if err := runGit(baseDir, "init", "--bare", project.RemoteDir); err != nil {
+	if err := runGit(env, baseDir, "init", "--bare", project.RemoteDir); err != nil {
 		return project, fmt.Errorf("git init --bare: %w", err)
 	}
 
 	// Step 2: Clone
 	project.WorkDir = filepath.Join(baseDir, "project")
-	if err := runGit(baseDir, "clone", project.RemoteDir, project.WorkDir); err != nil {
+	if err := runGit(env, baseDir, "clone", project.RemoteDir, project.WorkDir); err != nil {
 		return project, fmt.Errorf("git clone: %w", err)
 	}
 
 	// Step 3: Set git identity
-	if err := runGit(project.WorkDir, "config", "user.email", "test@example.com"); err != nil {
+	if err := runGit(env, project.WorkDir, "config", "user.email", "test@example.com"); err != nil {
 		return project, err
 	}
-	if err := runGit(project.WorkDir, "config", "user.name", "Test User"); err != nil {
+	if err := runGit(env, project.WorkDir, "config", "user.name", "Test User"); err != nil {
 		return project, err
 	}
 
-	// Step 4: Create initial files and commit
-	for relPath, content := range cfg.Files {
-		absPath := filepath.Join(project.WorkDir, relPath)
-		if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
-			return project, err
+	// Step 4: Copy fixtures, create initial files, and commit
+	for _, name := range cfg.Fixtures {
+		src := filepath.Join(cfg.FixtureRoot, name)
+		if fi, err := os.Stat(src); err != nil || !fi.IsDir() {
+			return project, fmt.Errorf("fixture %q: no directory at %s", name, src)
 		}
-		if err := os.WriteFile(absPath, []byte(content), 0644); err != nil {
-			return project, err
+		if err := copyTree(src, project.WorkDir); err != nil {
+			return project, fmt.Errorf("fixture %q: %w", name, err)
 		}
 	}
+	if err := writeFiles(project.WorkDir, cfg.Files); err != nil {
+		return project, err
+	}
 
 	// Always create at least one file for initial commit
 	readmePath := filepath.Join(project.WorkDir, "README.md")
@@ -73,45 +132,44 @@ func ProvisionProject(realPluginDir string, cfg ProjectConfig) (*TestProject, er
 		}
 	}
 
-	if err := runGit(project.WorkDir, "add", "-A"); err != nil {
+	if err := runGit(env, project.WorkDir, "add", "-A"); err != nil {
 		return project, err
 	}
-	if err := runGit(project.WorkDir, "commit", "-m", "initial commit"); err != nil {
+	if err := runGit(env, project.WorkDir, "commit", "-m", "initial commit"); err != nil {
 		return project, err
 	}
-	if err := runGit(project.WorkDir, "push", "-u", "origin", "HEAD"); err != nil {
+	if err := runGit(env, project.WorkDir, "push", "-u", "origin", "HEAD"); err != nil {
 		return project, err
 	}
 
-	// Step 5: Create local marketplace with symlink
-	if cfg.PluginLink && realPluginDir != "" {
-		project.PluginDir = filepath.Join(baseDir, "local-plugins")
-		marketplaceDir := filepath.Join(project.PluginDir, ".claude-plugin")
-		if err := os.MkdirAll(marketplaceDir, 0755); err != nil {
-			return project, err
-		}
+	branch, err := runGitOutput(env, project.WorkDir, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return project, err
+	}
+	project.DefaultBranch = branch
 
-		catalog := map[string]interface{}{
-			"name": "test-marketplace",
-			"plugins": []map[string]string{
-				{"name": "yf", "dir": "plugins/yf"},
-			},
-		}
-		catalogJSON, _ := json.MarshalIndent(catalog, "", "  ")
-		if err := os.WriteFile(filepath.Join(marketplaceDir, "marketplace.json"), catalogJSON, 0644); err != nil {
-			return project, err
-		}
+	// Step 4b: Seed history, branches, and tags
+	if err := seedHistory(project, cfg, env); err != nil {
+		return project, err
+	}
 
-		// Symlink plugins/yf -> real plugin source
-		pluginsDir := filepath.Join(project.PluginDir, "plugins")
-		if err := os.MkdirAll(pluginsDir, 0755); err != nil {
+	// Step 4c: Add worktrees
+	if len(cfg.Worktrees) > 0 {
+		worktrees, err := createWorktrees(env, project.WorkDir, cfg.Worktrees)
+		if err != nil {
 			return project, err
 		}
+		project.Worktrees = worktrees
+	}
 
-		yfSource := filepath.Join(realPluginDir, "plugins", "yf")
-		if err := os.Symlink(yfSource, filepath.Join(pluginsDir, "yf")); err != nil {
-			return project, fmt.Errorf("symlink yf: %w", err)
+	// Step 5: Create local marketplace mirroring the catalog's plugins
+	if cfg.PluginLink && realPluginDir != "" {
+		project.PluginDir = filepath.Join(baseDir, "local-plugins")
+		plugins, err := linkMarketplace(realPluginDir, project.PluginDir, cfg.Plugins)
+		if err != nil {
+			return project, err
 		}
+		project.Plugins = plugins
 	}
 
 	// Step 6: Enable yf if requested
@@ -120,24 +178,147 @@ func ProvisionProject(realPluginDir string, cfg ProjectConfig) (*TestProject, er
 		if err := os.MkdirAll(yfDir, 0755); err != nil {
 			return project, err
 		}
-		yfConfig := `{"enabled": true, "config": {"artifact_dir": "docs"}}`
-		if err := os.WriteFile(filepath.Join(yfDir, "config.json"), []byte(yfConfig), 0644); err != nil {
+		yfConfig, err := buildYfConfig(cfg.YfConfig)
+		if err != nil {
+			return project, err
+		}
+		if err := os.WriteFile(filepath.Join(yfDir, "config.json"), yfConfig, 0644); err != nil {
 			return project, err
 		}
 
 		// Run preflight
-		if project.PluginDir != "" || realPluginDir != "" {
-			preflightScript := filepath.Join(realPluginDir, "plugins", "yf", "scripts", "plugin-preflight.sh")
-			cmd := exec.Command("bash", preflightScript)
-			cmd.Dir = project.WorkDir
-			cmd.Env = append(os.Environ(), "CLAUDE_PROJECT_DIR="+project.WorkDir)
-			cmd.CombinedOutput() // best-effort
+		if cfg.Preflight != "skip" && (project.PluginDir != "" || realPluginDir != "") {
+			project.Preflight = runPreflight(env, realPluginDir, project.WorkDir)
+			if cfg.Preflight == "required" && project.Preflight.ExitCode != 0 {
+				return project, fmt.Errorf("preflight failed (exit %d):\n%s",
+					project.Preflight.ExitCode, strings.TrimSpace(project.Preflight.Output))
+			}
+		}
+	}
+
+	// Step 7: Seed the yf task store
+	if len(cfg.YfStore) > 0 {
+		ids, err := seedStore(project.WorkDir, cfg.YfStore, env.Now())
+		if err != nil {
+			return project, err
 		}
+		project.StoreIDs = ids
 	}
 
 	return project, nil
 }
 
+// runPreflight runs plugin-preflight.sh in workDir and records its result.
+func runPreflight(env *Environ, realPluginDir, workDir string) *PreflightResult {
+	preflightScript := filepath.Join(realPluginDir, "plugins", "yf", "scripts", "plugin-preflight.sh")
+	cmd := exec.Command("bash", preflightScript)
+	cmd.Dir = workDir
+	cmd.Env = append(env.Vars(), "CLAUDE_PROJECT_DIR="+workDir)
+	out, err := cmd.CombinedOutput()
+	return &PreflightResult{Output: string(out), ExitCode: exitCodeOf(err)}
+}
+
+// seedHistory applies the configured commits, branches, tags, and checkout,
+// then pushes everything to the remote if requested.
+func seedHistory(project *TestProject, cfg ProjectConfig, env *Environ) error {
+	dir := project.WorkDir
+	current := project.DefaultBranch
+
+	for i, c := range cfg.Commits {
+		if c.Branch != "" && c.Branch != current {
+			if err := checkoutBranch(env, dir, c.Branch); err != nil {
+				return err
+			}
+			current = c.Branch
+		}
+		if err := writeFiles(dir, c.Files); err != nil {
+			return err
+		}
+		if err := runGit(env, dir, "add", "-A"); err != nil {
+			return err
+		}
+
+		msg := c.Message
+		if msg == "" {
+			msg = fmt.Sprintf("commit %d", i+1)
+		}
+		args := []string{"commit", "--allow-empty", "-m", msg}
+		if c.Author != "" {
+			args = append(args, "--author", c.Author)
+		}
+		var dates []string
+		if c.Date != "" {
+			dates = append(dates, "GIT_AUTHOR_DATE="+c.Date, "GIT_COMMITTER_DATE="+c.Date)
+		}
+		if err := runGitEnv(env, dir, dates, args...); err != nil {
+			return fmt.Errorf("seeding commit %d: %w", i+1, err)
+		}
+	}
+
+	for _, b := range cfg.Branches {
+		args := []string{"branch", b.Name}
+		if b.From != "" {
+			args = append(args, b.From)
+		}
+		if err := runGit(env, dir, args...); err != nil {
+			return fmt.Errorf("creating branch %s: %w", b.Name, err)
+		}
+	}
+
+	for _, t := range cfg.Tags {
+		args := []string{"tag"}
+		if t.Message != "" {
+			args = append(args, "-a", "-m", t.Message)
+		}
+		args = append(args, t.Name)
+		if t.Ref != "" {
+			args = append(args, t.Ref)
+		}
+		if err := runGit(env, dir, args...); err != nil {
+			return fmt.Errorf("creating tag %s: %w", t.Name, err)
+		}
+	}
+
+	target := cfg.Checkout
+	if target == "" {
+		target = project.DefaultBranch
+	}
+	if target != current {
+		if err := runGit(env, dir, "checkout", "-q", target); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Push {
+		if err := runGit(env, dir, "push", "-u", "origin", "--all"); err != nil {
+			return err
+		}
+		if err := runGit(env, dir, "push", "origin", "--tags"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkoutBranch switches to a branch, creating it from HEAD if it doesn't exist.
+func checkoutBranch(env *Environ, dir, name string) error {
+	if _, err := runGitOutput(env, dir, "rev-parse", "--verify", "--quiet", "refs/heads/"+name); err == nil {
+		return runGit(env, dir, "checkout", "-q", name)
+	}
+	return runGit(env, dir, "checkout", "-q", "-b", name)
+}
+
+// writeFiles writes inline files relative to dir.
+func writeFiles(dir string, files map[string]FileSpec) error {
+	for relPath, spec := range files {
+		if err := spec.write(filepath.Join(dir, relPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Cleanup removes all test project files.
 func (p *TestProject) Cleanup() {
 	if p != nil && p.BaseDir != "" {
@@ -146,18 +327,38 @@ func (p *TestProject) Cleanup() {
 }
 
 // runGit runs a git command in the given directory.
-func runGit(dir string, args ...string) error {
+func runGit(env *Environ, dir string, args ...string) error {
+	return runGitEnv(env, dir, nil, args...)
+}
+
+// runGitEnv runs a git command with additional environment variables.
+func runGitEnv(env *Environ, dir string, extra []string, args ...string) error {
+	_, err := gitOutput(env, dir, extra, args...)
+	return err
+}
+
+// runGitOutput runs a git command and returns its trimmed stdout.
+func runGitOutput(env *Environ, dir string, args ...string) (string, error) {
+	return gitOutput(env, dir, nil, args...)
+}
+
+// gitOutput runs git with the test identity plus extra, returning trimmed stdout.
+func gitOutput(env *Environ, dir string, extra []string, args ...string) (string, error) {
 	cmd := exec.Command("git", args...)
 	cmd.Dir = dir
-	cmd.Env = append(os.Environ(),
+	cmd.Env = append(env.Vars(),
 		"GIT_AUTHOR_NAME=Test User",
 		"GIT_AUTHOR_EMAIL=test@example.com",
 		"GIT_COMMITTER_NAME=Test User",
 		"GIT_COMMITTER_EMAIL=test@example.com",
 	)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("git %v: %w\n%s", args, err, string(out))
+	cmd.Env = append(cmd.Env, extra...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %v: %w\n%s%s", args, err, stdout.String(), stderr.String())
 	}
-	return nil
+	return strings.TrimSpace(stdout.String()), nil
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ResultsFile is the JSON form of a run written by -results and read back
+// by -baseline and the report command.
+type ResultsFile struct {
+	Passed    int              `json:"passed"`
+	Failed    int              `json:"failed"`
+	Scenarios []ScenarioRecord `json:"scenarios"`
+}
+
+// ScenarioRecord is one scenario's Report.
+type ScenarioRecord struct {
+	Name      string           `json:"name"`
+	Path      string           `json:"path"`
+	Error     string           `json:"error,omitempty"`
+	Skipped   bool             `json:"skipped,omitempty"`
+	Artifacts string           `json:"artifacts,omitempty"`
+	Bundle    string           `json:"bundle,omitempty"`
+	Preflight *PreflightResult `json:"preflight,omitempty"` // provisioning preflight run, if any
+	Results   []ResultRecord   `json:"results"`
+}
+
+// ResultRecord is one assertion's StepResult. Assertion is the summary
+// main prints, so it doubles as the assertion's identity.
+type ResultRecord struct {
+	Step      string `json:"step"`
+	Assertion string `json:"assertion"`
+	Pass      bool   `json:"pass"`
+	Detail    string `json:"detail,omitempty"`
+	Artifacts string `json:"artifacts,omitempty"`
+	Attempts  int    `json:"attempts,omitempty"`
+}
+
+// Add records a scenario's report.
+func (f *ResultsFile) Add(scenario Scenario, report Report) {
+	rec := ScenarioRecord{
+		Name:      scenario.Name,
+		Path:      scenario.Path,
+		Error:     report.Error,
+		Skipped:   report.Skipped,
+		Artifacts: report.Artifacts,
+		Bundle:    report.Bundle,
+		Preflight: report.Preflight,
+		Results:   []ResultRecord{},
+	}
+	for _, r := range report.Results {
+		if r.Pass {
+			f.Passed++
+		} else {
+			f.Failed++
+		}
+		rec.Results = append(rec.Results, ResultRecord{
+			Step:      r.StepName,
+			Assertion: assertionSummary(r.Assertion),
+			Pass:      r.Pass,
+			Detail:    r.Detail,
+			Artifacts: r.Artifacts,
+			Attempts:  r.Attempts,
+		})
+	}
+	f.Scenarios = append(f.Scenarios, rec)
+}
+
+// FailedScenarios names the scenarios with a failed assertion or an error.
+func (f *ResultsFile) FailedScenarios() []string {
+	var names []string
+	for _, s := range f.Scenarios {
+		failed := s.Error != ""
+		for _, r := range s.Results {
+			failed = failed || !r.Pass
+		}
+		if failed {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// Write saves the results as indented JSON.
+func (f *ResultsFile) Write(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// readResults loads a file written by -results.
+func readResults(path string) (*ResultsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f ResultsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Comparison classes, in the order they are printed.
+const (
+	newFailure   = "new failure"
+	fixed        = "fixed"
+	stillFailing = "still failing"
+	added        = "added"
+	removed      = "removed"
+)
+
+// Change is one assertion whose class against the baseline is known.
+type Change struct {
+	Class string
+	Key   string // scenario path / step / assertion
+	Pass  bool   // current result; baseline result for removed
+}
+
+// Comparison classifies every assertion of a run against a baseline.
+type Comparison struct {
+	Changes []Change
+}
+
+// Count returns how many assertions fall in class.
+func (c Comparison) Count(class string) int {
+	n := 0
+	for _, ch := range c.Changes {
+		if ch.Class == class {
+			n++
+		}
+	}
+	return n
+}
+
+// Regressions counts new failures, including failing assertions the
+// baseline did not have.
+func (c Comparison) Regressions() int {
+	n := 0
+	for _, ch := range c.Changes {
+		if ch.Class == newFailure || (ch.Class == added && !ch.Pass) {
+			n++
+		}
+	}
+	return n
+}
+
+// compareResults matches assertions by scenario path, step, and assertion
+// summary; names aren't unique across files, paths are. Every scenario has
+// a "(scenario) / no error" assertion that fails when it errored (in setup,
+// say), so a scenario that breaks is a new failure; assertions it didn't
+// get to run aren't counted as removed. Scenarios skipped in either run
+// are left out.
+func compareResults(baseline, current *ResultsFile) Comparison {
+	skipped := map[string]bool{}
+	for _, f := range []*ResultsFile{baseline, current} {
+		for _, s := range f.Scenarios {
+			if s.Skipped {
+				skipped[s.id()] = true
+			}
+		}
+	}
+	errored := map[string]bool{}
+	for _, s := range current.Scenarios {
+		if s.Error != "" {
+			errored[s.id()] = true
+		}
+	}
+	before, order, scenarioOf := resultKeys(baseline, skipped)
+	after, currentOrder, _ := resultKeys(current, skipped)
+
+	var c Comparison
+	for _, key := range currentOrder {
+		pass := after[key]
+		was, ok := before[key]
+		switch {
+		case !ok:
+			c.Changes = append(c.Changes, Change{added, key, pass})
+		case was && !pass:
+			c.Changes = append(c.Changes, Change{newFailure, key, pass})
+		case !was && pass:
+			c.Changes = append(c.Changes, Change{fixed, key, pass})
+		case !was && !pass:
+			c.Changes = append(c.Changes, Change{stillFailing, key, pass})
+		}
+	}
+	for _, key := range order {
+		if _, ok := after[key]; !ok && !errored[scenarioOf[key]] {
+			c.Changes = append(c.Changes, Change{removed, key, before[key]})
+		}
+	}
+	rank := map[string]int{newFailure: 0, fixed: 1, stillFailing: 2, added: 3, removed: 4}
+	sort.SliceStable(c.Changes, func(i, j int) bool {
+		return rank[c.Changes[i].Class] < rank[c.Changes[j].Class]
+	})
+	return c
+}
+
+// scenarioOK is the assertion standing for "the scenario ran without error".
+var scenarioOK = ResultRecord{Step: "(scenario)", Assertion: "no error"}
+
+// resultKeys maps each assertion's identity to whether it passed, and to
+// its scenario's id. Repeats of the same identity within a step are
+// numbered so they stay distinct.
+func resultKeys(f *ResultsFile, skip map[string]bool) (map[string]bool, []string, map[string]string) {
+	keys := map[string]bool{}
+	scenarioOf := map[string]string{}
+	var order []string
+	for _, s := range f.Scenarios {
+		if skip[s.id()] {
+			continue
+		}
+		ok := scenarioOK
+		ok.Pass = s.Error == ""
+		for _, r := range append([]ResultRecord{ok}, s.Results...) {
+			base := s.id() + " / " + r.Step + " / " + r.Assertion
+			key := base
+			for n := 2; ; n++ {
+				if _, dup := keys[key]; !dup {
+					break
+				}
+				key = fmt.Sprintf("%s #%d", base, n)
+			}
+			keys[key] = r.Pass
+			scenarioOf[key] = s.id()
+			order = append(order, key)
+		}
+	}
+	return keys, order, scenarioOf
+}
+
+// id identifies the scenario across runs: its file, or its name when the
+// record has no path.
+func (s ScenarioRecord) id() string {
+	if s.Path == "" {
+		return s.Name
+	}
+	return filepath.ToSlash(filepath.Clean(s.Path))
+}
+
+// Print writes the counts and lists every change except assertions that
+// still fail, which only get a count.
+func (c Comparison) Print(w io.Writer, baselinePath string) {
+	fmt.Fprintf(w, "\n=== Baseline (%s): %d new failures, %d fixed, %d still failing, %d added, %d removed ===\n",
+		baselinePath, c.Count(newFailure), c.Count(fixed), c.Count(stillFailing), c.Count(added), c.Count(removed))
+	for _, ch := range c.Changes {
+		if ch.Class == stillFailing {
+			continue
+		}
+		status := "PASS"
+		if !ch.Pass {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "  %-13s %s  %s\n", ch.Class, status, ch.Key)
+	}
+}
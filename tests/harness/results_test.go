@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// scenarioRecord builds a scenario's record from its results.
+func scenarioRecord(name, path string, results ...ResultRecord) ScenarioRecord {
+	return ScenarioRecord{Name: name, Path: path, Results: results}
+}
+
+// result is an assertion result of step "s".
+func result(assertion string, pass bool) ResultRecord {
+	return ResultRecord{Step: "s", Assertion: assertion, Pass: pass}
+}
+
+func TestCompareResultsClasses(t *testing.T) {
+	baseline := &ResultsFile{Scenarios: []ScenarioRecord{
+		scenarioRecord("A", "a.yaml",
+			result("still", false), result("breaks", true), result("heals", false), result("gone", true)),
+	}}
+	current := &ResultsFile{Scenarios: []ScenarioRecord{
+		scenarioRecord("A", "a.yaml",
+			result("still", false), result("breaks", false), result("heals", true), result("new", false)),
+	}}
+
+	got := compareResults(baseline, current).Changes
+	want := []Change{
+		{newFailure, "a.yaml / s / breaks", false},
+		{fixed, "a.yaml / s / heals", true},
+		{stillFailing, "a.yaml / s / still", false},
+		{added, "a.yaml / s / new", false},
+		{removed, "a.yaml / s / gone", true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes:\n got %v\nwant %v", got, want)
+	}
+	if n := compareResults(baseline, current).Regressions(); n != 2 {
+		t.Errorf("Regressions() = %d, want 2 (new failure and failing added)", n)
+	}
+}
+
+func TestCompareResultsKeysByPath(t *testing.T) {
+	// Two files share a name; only the second one regresses
+	baseline := &ResultsFile{Scenarios: []ScenarioRecord{
+		scenarioRecord("Same", "unit/x.yaml", result("ok", true)),
+		scenarioRecord("Same", "integ/x.yaml", result("ok", true)),
+	}}
+	current := &ResultsFile{Scenarios: []ScenarioRecord{
+		scenarioRecord("Same", "unit/x.yaml", result("ok", true)),
+		scenarioRecord("Same", "integ/x.yaml", result("ok", false)),
+	}}
+
+	c := compareResults(baseline, current)
+	if c.Count(newFailure) != 1 || c.Count(added) != 0 || c.Count(removed) != 0 {
+		t.Fatalf("changes = %v", c.Changes)
+	}
+	if key := c.Changes[0].Key; key != "integ/x.yaml / s / ok" {
+		t.Errorf("new failure key = %q", key)
+	}
+}
+
+func TestCompareResultsSkippedAndErrors(t *testing.T) {
+	skipped := scenarioRecord("Integ", "integ.yaml")
+	skipped.Skipped = true
+	errored := scenarioRecord("Broken", "broken.yaml", result("ok", true))
+	errored.Error = "provisioning: boom"
+
+	baseline := &ResultsFile{Scenarios: []ScenarioRecord{
+		scenarioRecord("Integ", "integ.yaml", result("ok", true)),
+		scenarioRecord("Broken", "broken.yaml", result("ok", true)),
+	}}
+	current := &ResultsFile{Scenarios: []ScenarioRecord{skipped, errored}}
+
+	got := compareResults(baseline, current).Changes
+	want := []Change{{newFailure, "broken.yaml / (scenario) / no error", false}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestCompareResultsSetupFailureRegresses(t *testing.T) {
+	// The scenario passed before; now setup fails and no step runs
+	broken := scenarioRecord("A", "a.yaml")
+	broken.Error = "setup command 1 failed (exit 1)"
+	baseline := &ResultsFile{Scenarios: []ScenarioRecord{
+		scenarioRecord("A", "a.yaml", result("one", true), result("two", true)),
+	}}
+	current := &ResultsFile{Scenarios: []ScenarioRecord{broken}}
+
+	c := compareResults(baseline, current)
+	want := []Change{{newFailure, "a.yaml / (scenario) / no error", false}}
+	if !reflect.DeepEqual(c.Changes, want) {
+		t.Errorf("changes:\n got %v\nwant %v", c.Changes, want)
+	}
+	if c.Regressions() != 1 {
+		t.Errorf("Regressions() = %d, want 1", c.Regressions())
+	}
+
+	// Fixing setup brings the scenario and its assertions back
+	c = compareResults(current, baseline)
+	want = []Change{
+		{fixed, "a.yaml / (scenario) / no error", true},
+		{added, "a.yaml / s / one", true},
+		{added, "a.yaml / s / two", true},
+	}
+	if !reflect.DeepEqual(c.Changes, want) {
+		t.Errorf("changes after fix:\n got %v\nwant %v", c.Changes, want)
+	}
+}
+
+func TestCompareResultsNumbersRepeats(t *testing.T) {
+	baseline := &ResultsFile{Scenarios: []ScenarioRecord{
+		scenarioRecord("A", "a.yaml", result("exit_code(0)", true), result("exit_code(0)", true)),
+	}}
+	current := &ResultsFile{Scenarios: []ScenarioRecord{
+		scenarioRecord("A", "a.yaml", result("exit_code(0)", true), result("exit_code(0)", false)),
+	}}
+
+	got := compareResults(baseline, current).Changes
+	want := []Change{{newFailure, "a.yaml / s / exit_code(0) #2", false}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestCompareResultsFallsBackToName(t *testing.T) {
+	baseline := &ResultsFile{Scenarios: []ScenarioRecord{scenarioRecord("A", "", result("ok", false))}}
+	current := &ResultsFile{Scenarios: []ScenarioRecord{scenarioRecord("A", "", result("ok", true))}}
+
+	got := compareResults(baseline, current).Changes
+	want := []Change{{fixed, "A / s / ok", true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes:\n got %v\nwant %v", got, want)
+	}
+}
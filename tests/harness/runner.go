@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -10,6 +13,9 @@ import (
 	"time"
 )
 
+// defaultSession names the session used by steps that don't set session:.
+const defaultSession = "default"
+
 // Options controls runner behavior.
 type Options struct {
 	PluginDir       string
@@ -19,6 +25,15 @@ type Options struct {
 	IntegrationOnly bool
 	Verbose         bool
 	Timeout         time.Duration
+	Templates       *TemplateCache // nil provisions every project from scratch
+	Hermetic        bool           // force hermetic mode for every scenario
+	PassEnv         []string       // host variables passed through in hermetic mode
+	ArtifactsDir    string         // save per-step outputs and transcripts here
+	ScenarioRoot    string         // directory artifact dirs are named relative to
+	PauseOnFailure  bool           // open a shell after a failing step
+	KeepOnFailure   bool           // bundle a failing scenario's project into ArtifactsDir
+	RerunCommand    string         // command line that reruns a scenario, minus its path
+	PromptTimeout   time.Duration  // per-prompt limit unless the step sets timeout:
 }
 
 // RunScenario executes a single test scenario and returns a report.
@@ -30,7 +45,7 @@ func RunScenario(scenario Scenario, opts Options) Report {
 		if opts.Verbose {
 			fmt.Printf("  [skip] integration scenario in unit-only mode\n")
 		}
-		return Report{ScenarioName: scenario.Name}
+		return Report{ScenarioName: scenario.Name, Skipped: true}
 	}
 
 	// Skip unit tests in integration-only mode
@@ -38,26 +53,83 @@ func RunScenario(scenario Scenario, opts Options) Report {
 		if opts.Verbose {
 			fmt.Printf("  [skip] unit scenario in integration-only mode\n")
 		}
-		return Report{ScenarioName: scenario.Name}
+		return Report{ScenarioName: scenario.Name, Skipped: true}
 	}
 
 	// Resolve plugin dir early (needed for project provisioning)
 	pluginDir := resolvePluginDir(scenario.PluginDir, opts.PluginDir)
 
-	// Provision test project if configured
+	// Build the base environment for every process in the scenario
+	env, err := NewEnviron(opts.Hermetic || scenario.Hermetic,
+		append(append([]string{}, opts.PassEnv...), scenario.PassEnv...))
+	if err != nil {
+		return Report{ScenarioName: scenario.Name, Error: err.Error()}
+	}
+	if !opts.Keep {
+		defer env.Cleanup()
+	}
+	if scenario.Now != "" {
+		clock, err := NewClock(scenario.Now)
+		if err != nil {
+			return Report{ScenarioName: scenario.Name, Error: err.Error()}
+		}
+		env.Clock = clock
+	}
+
+	// Per-step outputs are saved here when requested
+	var artifacts *Artifacts
+	if opts.ArtifactsDir != "" {
+		artifacts, err = NewArtifacts(opts.ArtifactsDir, opts.ScenarioRoot, scenario)
+		if err != nil {
+			return Report{ScenarioName: scenario.Name, Error: err.Error()}
+		}
+	}
+
 	var project *TestProject
 	var remoteDir string
 	var localPluginDir string
+	var worktrees map[string]string
+	workDir := opts.WorkDir
+
+	// A failing scenario's tree is bundled before cleanup removes it
+	bundle := func(report Report) Report {
+		if artifacts != nil {
+			report.Artifacts = artifacts.Dir
+		}
+		if !opts.KeepOnFailure || artifacts == nil || (report.Error == "" && !anyFailed(report.Results)) {
+			return report
+		}
+		baseDir := workDir
+		if project != nil {
+			baseDir = project.BaseDir
+		}
+		rerun := opts.RerunCommand + " " + shellQuote(scenario.Path)
+		manifest := newBundleManifest(scenario, report.Results, report.Error, baseDir, rerun)
+		path, err := artifacts.Bundle(baseDir, manifest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  Bundle error: %v\n", err)
+			return report
+		}
+		report.Bundle = path
+		return report
+	}
+
+	// Provision test project if configured
 
 	if scenario.Project != nil && scenario.Project.Git {
-		var err error
-		project, err = ProvisionProject(pluginDir, *scenario.Project)
+		cfg := *scenario.Project
+		cfg.FixtureRoot = fixtureRoot(scenario.Path, pluginDir)
+		if opts.Templates != nil {
+			project, err = opts.Templates.Provision(pluginDir, cfg, env)
+		} else {
+			project, err = ProvisionProject(pluginDir, cfg, env)
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error provisioning project: %v\n", err)
+			report := bundle(Report{ScenarioName: scenario.Name, Error: fmt.Sprintf("provisioning: %v", err)})
 			if project != nil && !opts.Keep {
 				project.Cleanup()
 			}
-			return Report{ScenarioName: scenario.Name}
+			return report
 		}
 		if !opts.Keep {
 			defer project.Cleanup()
@@ -65,10 +137,10 @@ func RunScenario(scenario Scenario, opts Options) Report {
 	}
 
 	// Create or use work dir
-	workDir := opts.WorkDir
 	if project != nil {
 		workDir = project.WorkDir
 		remoteDir = project.RemoteDir
+		worktrees = project.Worktrees
 		if project.PluginDir != "" {
 			localPluginDir = project.PluginDir
 		}
@@ -99,11 +171,55 @@ func RunScenario(scenario Scenario, opts Options) Report {
 	if localPluginDir != "" {
 		extraEnv["LOCAL_PLUGIN_DIR"] = localPluginDir
 	}
+	if project != nil {
+		for key, id := range project.StoreIDs {
+			extraEnv[storeIDEnv(key)] = id
+		}
+	}
 
-	// Determine effective plugin dir for sessions
-	sessionPluginDir := pluginDir
-	if localPluginDir != "" {
-		sessionPluginDir = localPluginDir
+	// Sessions load the linked (traced) plugins, or the real ones
+	sessionPluginDirs := sessionPluginDirs(project, pluginDir)
+	var hookTraceDir string
+	if project != nil && len(project.Plugins) > 0 {
+		hookTraceDir = filepath.Join(project.BaseDir, "hook-trace")
+		if err := os.MkdirAll(hookTraceDir, 0755); err != nil {
+			return bundle(Report{ScenarioName: scenario.Name, Error: err.Error()})
+		}
+	}
+
+	// Serve prompts from a local mock of the Messages API when scripted
+	var mock *MockAPI
+	if scenario.usesMockAPI() {
+		mock, err = StartMockAPI()
+		if err != nil {
+			return bundle(Report{ScenarioName: scenario.Name, Error: err.Error()})
+		}
+		defer mock.Close()
+		if opts.Verbose {
+			fmt.Printf("  Mock API: %s\n", mock.URL())
+		}
+	}
+
+	// Check preflight assertions against the provisioning run
+	var preflight *PreflightResult
+	if project != nil {
+		preflight = project.Preflight
+		for _, assertion := range scenario.Project.PreflightAssertions {
+			var pass bool
+			var detail string
+			if preflight == nil {
+				detail = "preflight did not run"
+			} else {
+				outcome := StepOutcome{Output: preflight.Output, ExitCode: preflight.ExitCode, Env: env}
+				pass, detail = checkAssertion(workDir, assertion, outcome)
+			}
+			results = append(results, StepResult{
+				StepName:  "preflight",
+				Assertion: assertion,
+				Pass:      pass,
+				Detail:    detail,
+			})
+		}
 	}
 
 	// Run setup commands
@@ -112,118 +228,491 @@ func RunScenario(scenario Scenario, opts Options) Report {
 		if opts.Verbose {
 			fmt.Printf("  [setup %d] %s\n", i+1, expanded)
 		}
-		out, code := runShell(workDir, expanded, pluginDir, extraEnv, opts.Timeout)
+		out, code := runShell(env, workDir, expanded, pluginDir, extraEnv, opts.Timeout)
 		if code != 0 {
 			fmt.Fprintf(os.Stderr, "  Setup command %d failed (exit %d): %s\n%s\n", i+1, code, expanded, out)
-			return Report{ScenarioName: scenario.Name, Results: results}
+			return bundle(Report{ScenarioName: scenario.Name, Results: results, Preflight: preflight,
+				Error: fmt.Sprintf("setup command %d failed (exit %d)", i+1, code)})
 		}
 	}
 
-	// Execute steps
-	session := &Session{WorkDir: workDir, PluginDir: sessionPluginDir}
+	// Checkpoint steps that later steps fork from
+	forks, err := NewForks(scenario.Steps, workDir, remoteDir)
+	if err != nil {
+		return bundle(Report{ScenarioName: scenario.Name, Results: results, Preflight: preflight, Error: err.Error()})
+	}
+	if !opts.Keep {
+		defer forks.Cleanup()
+	}
+
+	// Execute steps. Each named session keeps its own resume chain and
+	// allowed tools; steps without a name share the default session.
+	c := &stepContext{
+		scenario:          scenario,
+		opts:              opts,
+		env:               env,
+		workDir:           workDir,
+		remoteDir:         remoteDir,
+		pluginDir:         pluginDir,
+		extraEnv:          extraEnv,
+		worktrees:         worktrees,
+		sessionPluginDirs: sessionPluginDirs,
+		hookTraceDir:      hookTraceDir,
+		artifacts:         artifacts,
+		mock:              mock,
+		forks:             forks,
+		sessions:          map[string]*Session{},
+		policy:            scenario.OnSessionError,
+	}
+	if c.policy == "" {
+		c.policy = "fail"
+	}
 
-	for _, step := range scenario.Steps {
-		if step.NewSession {
-			session = &Session{WorkDir: workDir, PluginDir: sessionPluginDir}
+	var abortedAt string
+	for i := 0; i < len(scenario.Steps) && abortedAt == ""; i++ {
+		step := scenario.Steps[i]
+		savedSessions := saveSessions(c.sessions)
+		var clockAt time.Time
+		if env.Clock != nil {
+			clockAt = env.Clock.Now()
 		}
-		if len(step.AllowedTools) > 0 {
-			session.Allowed = step.AllowedTools
+		stepResults, stepDir, stepEnv := c.runStep(i, step)
+		results = append(results, stepResults...)
+
+		// Stop on the spot so the failure can be inspected before later
+		// steps change the state
+		if opts.PauseOnFailure && anyFailed(stepResults) {
+			switch pauseOnFailure(step, stepResults, env, stepDir, remoteDir, pluginDir, stepEnv) {
+			case pauseRetry:
+				results = results[:len(results)-len(stepResults)]
+				restoreSessions(c.sessions, savedSessions)
+				if env.Clock != nil {
+					// Otherwise advance_clock would apply twice
+					if err := env.Clock.Set(clockAt); err != nil {
+						fmt.Fprintf(os.Stderr, "  Clock error in step %q: %v\n", step.Name, err)
+					}
+				}
+				i--
+			case pauseAbort:
+				abortedAt = step.Name
+			}
 		}
+	}
 
-		var output string
-		var exitCode int
+	// Run teardown commands
+	for i, cmd := range scenario.Teardown {
+		expanded := expandVars(cmd, workDir, remoteDir)
+		if opts.Verbose {
+			fmt.Printf("  [teardown %d] %s\n", i+1, expanded)
+		}
+		runShell(env, workDir, expanded, pluginDir, extraEnv, opts.Timeout)
+	}
 
-		if step.Run != "" {
-			// Shell command step
-			expanded := expandVars(step.Run, workDir, remoteDir)
-			if opts.Verbose {
-				fmt.Printf("  [run: %s] %s\n", step.Name, expanded)
-			}
-			output, exitCode = runShell(workDir, expanded, pluginDir, extraEnv, opts.Timeout)
-			if opts.Verbose {
-				fmt.Printf("  [exit: %d] %s\n", exitCode, truncate(output, 200))
+	report := Report{ScenarioName: scenario.Name, Results: results, Preflight: preflight}
+	if abortedAt != "" {
+		report.Error = fmt.Sprintf("aborted after step %q", abortedAt)
+	}
+	return bundle(report)
+}
+
+// stepContext is the scenario state steps run against.
+type stepContext struct {
+	scenario          Scenario
+	opts              Options
+	env               *Environ
+	workDir           string
+	remoteDir         string
+	pluginDir         string
+	extraEnv          map[string]string
+	worktrees         map[string]string // worktree name -> dir
+	sessionPluginDirs []string
+	hookTraceDir      string // set when plugins are traced
+	artifacts         *Artifacts
+	mock              *MockAPI
+	forks             *Forks
+	sessions          map[string]*Session // by session name
+	policy            string              // on_session_error, defaulted
+}
+
+// newSession starts a session in the work dir with the scenario's plugins.
+func (c *stepContext) newSession() *Session {
+	s := &Session{WorkDir: c.workDir, PluginDirs: c.sessionPluginDirs, Env: c.env, ExtraEnv: map[string]string{}}
+	if c.mock != nil {
+		s.ExtraEnv = c.mock.Env()
+	}
+	return s
+}
+
+// runStep runs the index'th step and returns its results, along with the
+// directory and extra environment it ran with.
+func (c *stepContext) runStep(i int, step Step) (results []StepResult, stepDir string, stepEnv map[string]string) {
+	stepDir, stepEnv = c.workDir, c.extraEnv
+	var err error
+
+	sessionName := step.Session
+	if sessionName == "" {
+		sessionName = defaultSession
+	}
+	session, ok := c.sessions[sessionName]
+	if !ok || step.NewSession {
+		session = c.newSession()
+		c.sessions[sessionName] = session
+	}
+
+	// A fork rewinds the files and branches off the checkpointed conversation
+	if step.ForkFrom != "" {
+		cp, err := c.forks.Restore(step.ForkFrom)
+		if err != nil {
+			results = append(results, StepResult{
+				StepName:  step.Name,
+				Assertion: Assertion{Type: "fork_from", Value: step.ForkFrom},
+				Detail:    err.Error(),
+			})
+			return
+		}
+		session = c.newSession()
+		session.ID, session.Allowed, session.Fork = cp.SessionID, cp.Allowed, cp.SessionID != ""
+		c.sessions[sessionName] = session
+	}
+	if len(step.AllowedTools) > 0 {
+		session.Allowed = step.AllowedTools
+	}
+
+	// Steps run in the project unless pinned to a worktree
+	if step.InWorktree != "" {
+		wtDir, ok := c.worktrees[step.InWorktree]
+		if !ok {
+			results = append(results, StepResult{
+				StepName:  step.Name,
+				Assertion: Assertion{Type: "in_worktree", Value: step.InWorktree},
+				Detail:    fmt.Sprintf("unknown worktree %q", step.InWorktree),
+			})
+			return
+		}
+		stepDir = wtDir
+		stepEnv = mergeEnv(c.extraEnv, map[string]string{
+			"WORK_DIR":     c.workDir,
+			"WORKTREE_DIR": wtDir,
+		})
+	}
+	session.WorkDir = stepDir
+
+	if step.AdvanceClock != "" {
+		d, err := parseClockDuration(step.AdvanceClock)
+		if err == nil && c.env.Clock == nil {
+			err = fmt.Errorf("scenario has no pinned clock (set now:)")
+		}
+		if err == nil {
+			err = c.env.Clock.Advance(d)
+		}
+		if err != nil {
+			results = append(results, StepResult{
+				StepName:  step.Name,
+				Assertion: Assertion{Type: "advance_clock", Value: step.AdvanceClock},
+				Detail:    err.Error(),
+			})
+			return
+		}
+	}
+
+	var stepArtifacts string
+	if c.artifacts != nil {
+		if stepArtifacts, err = c.artifacts.StepDir(i, step.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "  Artifacts error in step %q: %v\n", step.Name, err)
+			stepArtifacts = ""
+		}
+	}
+
+	var output string
+	var exitCode int
+	var sessionError string
+	var hookLog string
+	var attempts int // prompt attempts, including retries
+
+	// Snapshot before the step so diff assertions can see what it changed
+	var before Snapshot
+	if needsSnapshot(step.Assertions) {
+		snap, err := takeSnapshot(stepDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  Snapshot error in step %q: %v\n", step.Name, err)
+		} else {
+			before = snap
+		}
+	}
+
+	if step.Run != "" {
+		// Shell command step
+		expanded := expandVars(step.Run, c.workDir, c.remoteDir)
+		if c.opts.Verbose {
+			fmt.Printf("  [run: %s] %s\n", step.Name, expanded)
+		}
+		res := runShellOutput(c.env, stepDir, expanded, c.pluginDir, stepEnv, c.opts.Timeout)
+		output, exitCode = res.Output, res.ExitCode
+		if stepArtifacts != "" {
+			if err := c.artifacts.SaveRun(stepArtifacts, expanded, res); err != nil {
+				fmt.Fprintf(os.Stderr, "  Artifacts error in step %q: %v\n", step.Name, err)
 			}
-		} else if step.Prompt != "" {
-			if opts.UnitOnly {
-				if opts.Verbose {
-					fmt.Printf("  [skip: %s] (unit-only mode)\n", step.Name)
-				}
-				continue
+		}
+		if c.opts.Verbose {
+			fmt.Printf("  [exit: %d] %s\n", exitCode, truncate(output, 200))
+		}
+	} else if step.Prompt != "" {
+		if c.opts.UnitOnly {
+			if c.opts.Verbose {
+				fmt.Printf("  [skip: %s] (unit-only mode)\n", step.Name)
 			}
-			maxTurns := step.MaxTurns
-			if maxTurns == 0 {
-				maxTurns = 3
+			return
+		}
+		sendOpts := step.sendOptions(c.workDir, c.remoteDir)
+		if sendOpts.MaxTurns == 0 {
+			sendOpts.MaxTurns = 3
+		}
+		if c.opts.Verbose {
+			fmt.Printf("  [prompt: %s] %s\n", step.Name, truncate(step.Prompt, 80))
+		}
+		if c.hookTraceDir != "" {
+			hookLog = filepath.Join(c.hookTraceDir, fmt.Sprintf("step-%02d.jsonl", i+1))
+			session.ExtraEnv["HARNESS_HOOK_LOG"] = hookLog
+		}
+		if step.Timeout != "" {
+			sendOpts.Timeout, _ = time.ParseDuration(step.Timeout)
+		} else {
+			sendOpts.Timeout = c.opts.PromptTimeout
+		}
+
+		// Errors retry with backoff as session_retry says. Every
+		// attempt resumes from where the step started, and retries
+		// fork so the failed attempt's turns stay out of the
+		// conversation.
+		resumeID, resumeFork := session.ID, session.Fork
+		retry := c.scenario.sessionRetryPolicy()
+		var lastResult *Result
+		for attempts = 1; ; attempts++ {
+			session.ID, session.Fork = resumeID, resumeFork || (attempts > 1 && resumeID != "")
+			if c.mock != nil {
+				c.mock.Script(expandMockTurns(step.MockTurns, c.workDir, c.remoteDir))
 			}
-			if opts.Verbose {
-				fmt.Printf("  [prompt: %s] %s\n", step.Name, truncate(step.Prompt, 80))
+			if hookLog != "" {
+				os.Remove(hookLog)
 			}
-			result, err := session.Send(step.Prompt, maxTurns, opts.Verbose)
+			result, err := session.Send(step.Prompt, sendOpts, c.opts.Verbose)
+			lastResult = result
+			sessionError = classifySessionError(result, err)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "  Claude error in step %q: %v\n", step.Name, err)
 				output = err.Error()
 			} else {
 				output = result.Text
 			}
+			if sessionError == "" {
+				break
+			}
+
+			limit := retry.retries(sessionError, result)
+			if attempts > limit {
+				break
+			}
+			delay := retry.delay(attempts)
+			fmt.Fprintf(os.Stderr, "  Session error (%s) in step %q, retrying in %s (%d/%d)\n",
+				sessionError, step.Name, delay, attempts, limit)
+			time.Sleep(delay)
+		}
+		if stepArtifacts != "" {
+			if err := c.artifacts.SavePrompt(stepArtifacts, lastResult, c.env); err != nil {
+				fmt.Fprintf(os.Stderr, "  Artifacts error in step %q: %v\n", step.Name, err)
+			}
+		}
+	}
+
+	if err := c.forks.Save(i, step.Name, sessionName, session); err != nil {
+		fmt.Fprintf(os.Stderr, "  Fork checkpoint error in step %q: %v\n", step.Name, err)
+	}
+
+	outcome := StepOutcome{Output: output, ExitCode: exitCode, Env: c.env, SessionError: sessionError}
+	if hookLog != "" {
+		hooks, err := readHookTrace(hookLog)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  Hook trace error in step %q: %v\n", step.Name, err)
+		}
+		outcome.Hooks, outcome.HooksTraced = hooks, true
+		if stepArtifacts != "" {
+			c.artifacts.SaveFile(stepArtifacts, hookLog, "hooks.jsonl")
+		}
+		if c.opts.Verbose {
+			for _, h := range hooks {
+				fmt.Printf("  [hook] %s %s (exit %d, %s)\n", h.Event, h.Name(), h.ExitCode, h.Duration())
+			}
 		}
+	}
+	if before != nil {
+		after, err := takeSnapshot(stepDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  Snapshot error in step %q: %v\n", step.Name, err)
+		} else {
+			outcome.Diff = diffSnapshots(before, after)
+		}
+	}
 
-		// Run assertions
-		for _, assertion := range step.Assertions {
-			pass, detail := checkAssertion(workDir, assertion, output, exitCode)
+	// Every scripted turn should have been asked for, unless the session
+	// errored, which is reported on its own
+	if c.mock != nil && step.Prompt != "" {
+		outcome.Requests = c.mock.Requests()
+		if left := c.mock.Remaining(); left > 0 && sessionError == "" {
+			total := len(step.MockTurns)
 			results = append(results, StepResult{
 				StepName:  step.Name,
-				Assertion: assertion,
-				Pass:      pass,
-				Detail:    detail,
+				Assertion: Assertion{Type: "mock_turns", Value: fmt.Sprintf("consumed %d of %d", total-left, total)},
+				Detail:    fmt.Sprintf("%d of %d scripted turns were not used", left, total),
+				Artifacts: stepArtifacts,
+				Attempts:  attempts,
 			})
 		}
 	}
 
-	// Run teardown commands
-	for i, cmd := range scenario.Teardown {
-		expanded := expandVars(cmd, workDir, remoteDir)
-		if opts.Verbose {
-			fmt.Printf("  [teardown %d] %s\n", i+1, expanded)
-		}
-		runShell(workDir, expanded, pluginDir, extraEnv, opts.Timeout)
+	// An errored session fails the step unless the policy says to carry
+	// on or the step checks the session itself
+	if sessionError != "" && c.policy != "continue" && !hasAssertion(step.Assertions, "session_ok") {
+		results = append(results, StepResult{
+			StepName:  step.Name,
+			Assertion: Assertion{Type: "session_ok"},
+			Detail:    sessionErrorDetail(sessionError, output),
+			Artifacts: stepArtifacts,
+			Attempts:  attempts,
+		})
+		return
 	}
 
-	return Report{ScenarioName: scenario.Name, Results: results}
+	// Run assertions
+	for _, assertion := range step.Assertions {
+		pass, detail := checkAssertion(stepDir, assertion, outcome)
+		results = append(results, StepResult{
+			StepName:  step.Name,
+			Assertion: assertion,
+			Pass:      pass,
+			Detail:    detail,
+			Artifacts: stepArtifacts,
+			Attempts:  attempts,
+		})
+	}
+	return
 }
 
 // runShell executes a shell command in the given directory and returns output + exit code.
-func runShell(dir, command, pluginDir string, extraEnv map[string]string, timeout time.Duration) (string, int) {
+func runShell(env *Environ, dir, command, pluginDir string, extraEnv map[string]string, timeout time.Duration) (string, int) {
+	res := runShellOutput(env, dir, command, pluginDir, extraEnv, timeout)
+	return res.Output, res.ExitCode
+}
+
+// stepVars are the variables run steps get on top of the base environment.
+func stepVars(dir, pluginDir string, extraEnv map[string]string) []string {
+	vars := []string{
+		"WORK_DIR=" + dir,
+		"CLAUDE_PROJECT_DIR=" + dir,
+	}
+	if pluginDir != "" {
+		vars = append(vars, "PLUGIN_DIR="+pluginDir)
+	}
+	for k, v := range extraEnv {
+		vars = append(vars, k+"="+v)
+	}
+	return vars
+}
+
+// ShellResult is a shell command's output, combined and per stream.
+type ShellResult struct {
+	Output   string // stdout and stderr interleaved
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// runShellOutput is runShell keeping stdout and stderr apart as well.
+func runShellOutput(env *Environ, dir, command, pluginDir string, extraEnv map[string]string, timeout time.Duration) ShellResult {
 	if timeout == 0 {
 		timeout = 2 * time.Minute
 	}
 
-	cmd := exec.Command("bash", "-c", command)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := killableCommand(ctx, "bash", "-c", command)
 	cmd.Dir = dir
-	cmd.Env = append(os.Environ(),
-		"WORK_DIR="+dir,
-		"CLAUDE_PROJECT_DIR="+dir,
-	)
-	if pluginDir != "" {
-		cmd.Env = append(cmd.Env, "PLUGIN_DIR="+pluginDir)
+	cmd.Env = append(env.Vars(), stepVars(dir, pluginDir, extraEnv)...)
+
+	var combined, stdout, stderr bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&combined, &stdout)
+	cmd.Stderr = io.MultiWriter(&combined, &stderr)
+	err := cmd.Run()
+	res := ShellResult{
+		Output:   combined.String(),
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCodeOf(err),
 	}
-	for k, v := range extraEnv {
-		cmd.Env = append(cmd.Env, k+"="+v)
+	if ctx.Err() == context.DeadlineExceeded {
+		// Same exit code as timeout(1)
+		res.ExitCode = 124
+		res.Output += fmt.Sprintf("\n[harness] command timed out after %s\n", timeout)
 	}
+	return res
+}
 
-	output, err := cmd.CombinedOutput()
-	exitCode := 0
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				exitCode = status.ExitStatus()
-			} else {
-				exitCode = 1
-			}
-		} else {
-			exitCode = 1
+// killableCommand returns a command in its own process group; when ctx
+// ends the whole group is killed, so hooks and other children go too.
+func killableCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait forever on output pipes held open by orphaned children
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// exitCodeOf maps a command error to a shell-style exit code.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
 		}
 	}
+	return 1
+}
 
-	return string(output), exitCode
+// mergeEnv returns a copy of base with overrides applied.
+func mergeEnv(base, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// fixtureRoot finds the fixtures directory for a scenario: the nearest one
+// beside the scenario or in a directory above it, so scenarios in
+// subdirectories share tests/scenarios/fixtures, falling back to the plugin
+// dir's tests/scenarios/fixtures.
+func fixtureRoot(scenarioPath, pluginDir string) string {
+	dir, err := filepath.Abs(filepath.Dir(scenarioPath))
+	if err == nil {
+		for {
+			if fi, err := os.Stat(filepath.Join(dir, "fixtures")); err == nil && fi.IsDir() {
+				return filepath.Join(dir, "fixtures")
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return filepath.Join(pluginDir, "tests", "scenarios", "fixtures")
 }
 
 // resolvePluginDir determines the plugin directory to use.
@@ -250,6 +739,105 @@ func resolvePluginDir(scenarioDir, flagDir string) string {
 	return ""
 }
 
+// sessionRetryPolicy is session_retry with on_session_error: retry's
+// defaults filled in: one retry, on any category that can be retried.
+func (s Scenario) sessionRetryPolicy() RetryPolicy {
+	p := s.SessionRetry
+	if s.OnSessionError == "retry" {
+		if p.Attempts == 0 {
+			p.Attempts = 1
+		}
+		if len(p.On) == 0 {
+			p.On = retryCategories
+		}
+	}
+	return p
+}
+
+// usesMockAPI reports whether prompts should go to the mock API.
+func (s Scenario) usesMockAPI() bool {
+	if s.MockAPI {
+		return true
+	}
+	for _, step := range s.Steps {
+		if len(step.MockTurns) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// saveSessions copies each session's state so a retried step can start over.
+func saveSessions(sessions map[string]*Session) map[string]Session {
+	saved := make(map[string]Session, len(sessions))
+	for name, s := range sessions {
+		saved[name] = *s
+	}
+	return saved
+}
+
+// restoreSessions rewinds sessions to a saveSessions copy, dropping any
+// started since. claude appended the rewound turns to the saved session's
+// transcript, so a session that moved on forks on its next resume.
+func restoreSessions(sessions map[string]*Session, saved map[string]Session) {
+	for name, current := range sessions {
+		s, ok := saved[name]
+		if !ok {
+			delete(sessions, name)
+			continue
+		}
+		if s.ID != "" && current.ID != s.ID {
+			s.Fork = true
+		}
+		*current = s
+	}
+}
+
+// anyFailed reports whether any result failed.
+func anyFailed(results []StepResult) bool {
+	for _, r := range results {
+		if !r.Pass {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAssertion reports whether any assertion has the given type.
+func hasAssertion(assertions []Assertion, typ string) bool {
+	for _, a := range assertions {
+		if a.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionErrorDetail describes a failed session for the report.
+func sessionErrorDetail(category, output string) string {
+	if strings.TrimSpace(output) == "" {
+		return fmt.Sprintf("session error (%s)", category)
+	}
+	return fmt.Sprintf("session error (%s): %s", category, truncate(output, 200))
+}
+
+// sendOptions collects the step's Claude CLI options, expanding paths.
+func (step Step) sendOptions(workDir, remoteDir string) SendOptions {
+	o := SendOptions{
+		MaxTurns:           step.MaxTurns,
+		PermissionMode:     step.PermissionMode,
+		DisallowedTools:    step.DisallowedTools,
+		Model:              step.Model,
+		AppendSystemPrompt: step.AppendSystemPrompt,
+		Settings:           expandVars(step.Settings, workDir, remoteDir),
+		ExtraArgs:          step.ExtraArgs,
+	}
+	for _, cfg := range step.MCPConfig {
+		o.MCPConfig = append(o.MCPConfig, expandVars(cfg, workDir, remoteDir))
+	}
+	return o
+}
+
 // expandVars replaces $WORK_DIR and $REMOTE_DIR in shell commands.
 func expandVars(cmd, workDir, remoteDir string) string {
 	result := strings.ReplaceAll(cmd, "$WORK_DIR", workDir)
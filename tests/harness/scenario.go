@@ -3,32 +3,90 @@ package main
 // Scenario represents a YAML test scenario file.
 type Scenario struct {
 	Name      string         `yaml:"name"`
+	Path      string         `yaml:"-"`    // file the scenario was loaded from
 	Type      string         `yaml:"type"` // "unit" (default) or "integration"
+	Tags      []string       `yaml:"tags"` // free-form labels shown by -list
 	PluginDir string         `yaml:"plugin_dir"`
 	Remote    string         `yaml:"remote"`
 	Project   *ProjectConfig `yaml:"project"` // test project provisioning
 	Setup     []string       `yaml:"setup"`
 	Teardown  []string       `yaml:"teardown"`
 	Steps     []Step         `yaml:"steps"`
+	Hermetic  bool           `yaml:"hermetic"`        // isolate HOME, git config, PATH, TZ, and LANG
+	PassEnv   []string       `yaml:"env_passthrough"` // host variables kept in hermetic mode
+	Now       string         `yaml:"now"`             // pin the clock (RFC 3339) for date and git
+	MockAPI   bool           `yaml:"mock_api"`        // serve prompts from mock_turns (implied when a step has them)
+
+	// OnSessionError is what happens when a prompt step's session errors:
+	// "fail" (default) fails the step without checking its assertions,
+	// "retry" is "fail" after retrying any error but max_turns (see
+	// sessionRetryPolicy), and "continue" checks the assertions against
+	// whatever came back. Steps with a session_ok assertion always check
+	// their assertions.
+	OnSessionError string `yaml:"on_session_error"`
+
+	// SessionRetry retries the session errors it lists with backoff.
+	SessionRetry RetryPolicy `yaml:"session_retry"`
 }
 
 // Step is a single test step — either a Claude prompt or a shell command.
 type Step struct {
-	Name         string      `yaml:"name"`
-	Prompt       string      `yaml:"prompt"`
-	Run          string      `yaml:"run"`
-	MaxTurns     int         `yaml:"max_turns"`
-	AllowedTools []string    `yaml:"allowed_tools"`
-	NewSession   bool        `yaml:"new_session"`
-	Assertions   []Assertion `yaml:"assertions"`
+	Name         string   `yaml:"name"`
+	Prompt       string   `yaml:"prompt"`
+	Run          string   `yaml:"run"`
+	MaxTurns     int      `yaml:"max_turns"`
+	Timeout      string   `yaml:"timeout"` // kill the prompt after this duration (default: -prompt-timeout)
+	AllowedTools []string `yaml:"allowed_tools"`
+	NewSession   bool     `yaml:"new_session"`   // start the step's session afresh
+	Session      string   `yaml:"session"`       // named Claude session (default: "default")
+	ForkFrom     string   `yaml:"fork_from"`     // continue from an earlier step's or session's conversation and files
+	InWorktree   string   `yaml:"in_worktree"`   // run in a provisioned worktree instead of the project
+	AdvanceClock string   `yaml:"advance_clock"` // move the pinned clock forward before the step, e.g. "48h" or "2d"
+
+	// Claude CLI options for prompt steps
+	PermissionMode     string   `yaml:"permission_mode"` // e.g. "plan"
+	DisallowedTools    []string `yaml:"disallowed_tools"`
+	Model              string   `yaml:"model"`
+	AppendSystemPrompt string   `yaml:"append_system_prompt"`
+	Settings           string   `yaml:"settings"`   // file ($WORK_DIR expanded) or JSON string
+	MCPConfig          []string `yaml:"mcp_config"` // files ($WORK_DIR expanded) or JSON strings
+	ExtraArgs          []string `yaml:"extra_args"` // other CLI flags, validated at load
+
+	// MockTurns scripts the model's replies when the scenario uses the mock API
+	MockTurns []MockTurn `yaml:"mock_turns"`
+
+	Assertions []Assertion `yaml:"assertions"`
 }
 
 // Assertion defines a single check after a step completes.
 type Assertion struct {
-	Type   string `yaml:"type"`
-	Path   string `yaml:"path"`
-	Value  string `yaml:"value"`
-	Negate bool   `yaml:"negate"`
+	Type   string   `yaml:"type"`
+	Path   string   `yaml:"path"`
+	Paths  []string `yaml:"paths"` // glob list for only_changed and files_*; hook names for hook_order
+	Times  *int     `yaml:"times"` // exact count for hook_invoked (default: at least once)
+	Value  string   `yaml:"value"`
+	Negate bool     `yaml:"negate"`
+}
+
+// StepOutcome captures what a step produced, for assertions to inspect.
+type StepOutcome struct {
+	Output   string
+	ExitCode int
+	Diff     *FSDiff  // set only when the step has filesystem diff assertions
+	Env      *Environ // environment the step ran in, reused for git checks
+
+	// SessionError is "" unless the step's prompt failed, in which case it is
+	// SessionMaxTurns, SessionAPIError, or SessionCLICrash.
+	SessionError string
+
+	// Requests holds the model requests the mock API received during the
+	// step; nil when the scenario doesn't use the mock.
+	Requests []MockRequest
+
+	// Hooks lists the plugin hooks traced during a prompt step, in order.
+	// HooksTraced is false when the project has no linked plugins.
+	Hooks       []HookCall
+	HooksTraced bool
 }
 
 // StepResult records the pass/fail outcome of a single assertion within a step.
@@ -37,10 +95,17 @@ type StepResult struct {
 	Assertion Assertion
 	Pass      bool
 	Detail    string
+	Artifacts string // directory holding the step's saved outputs, if any
+	Attempts  int    // prompt attempts including retries; 0 for run steps
 }
 
 // Report aggregates all results for a scenario.
 type Report struct {
 	ScenarioName string
 	Results      []StepResult
+	Preflight    *PreflightResult // provisioning preflight run, if any
+	Error        string           // set when the scenario could not run to completion
+	Artifacts    string           // per-step outputs, when -artifacts-dir is set
+	Bundle       string           // archive of the failed scenario's tree (-keep-on-failure)
+	Skipped      bool             // not run because of -unit-only or -integration-only
 }
@@ -1,72 +1,333 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Session manages a Claude CLI session with --resume chaining.
 type Session struct {
-	ID        string
-	PluginDir string
-	WorkDir   string
-	Allowed   []string
+	ID         string
+	PluginDirs []string
+	WorkDir    string
+	Allowed    []string
+	Env        *Environ
+	ExtraEnv   map[string]string // added to Env, e.g. the mock API endpoint
+	Fork       bool              // the next resume branches off into a new session ID
 }
 
 // Result holds parsed JSON output from claude --output-format json.
 type Result struct {
 	SessionID string  `json:"session_id"`
+	Subtype   string  `json:"subtype"` // "success", "error_max_turns", "error_during_execution"
 	Text      string  `json:"result"`
 	NumTurns  int     `json:"num_turns"`
 	IsError   bool    `json:"is_error"`
+	APIStatus *int    `json:"api_error_status"` // HTTP status behind an API error
 	CostUSD   float64 `json:"total_cost_usd"`
+
+	Raw    []byte `json:"-"` // claude's stdout, as printed
+	Stderr string `json:"-"`
+}
+
+// Session error categories recorded in StepOutcome.SessionError.
+const (
+	SessionMaxTurns = "max_turns" // ran out of turns before finishing
+	SessionAPIError = "api_error" // the CLI reported an error result
+	SessionCLICrash = "cli_crash" // the CLI failed without a JSON result
+	SessionTimeout  = "timeout"   // killed after the prompt timeout
+)
+
+// defaultPromptTimeout bounds a prompt when the step sets no timeout.
+const defaultPromptTimeout = 10 * time.Minute
+
+// errSendTimeout marks a prompt killed at its deadline.
+var errSendTimeout = errors.New("claude timed out")
+
+// classifySessionError returns the error category for a Send outcome,
+// or "" when the prompt completed normally.
+func classifySessionError(result *Result, err error) string {
+	switch {
+	case errors.Is(err, errSendTimeout):
+		return SessionTimeout
+	case err != nil || result == nil:
+		return SessionCLICrash
+	case result.Subtype == "error_max_turns":
+		return SessionMaxTurns
+	case result.IsError || strings.HasPrefix(result.Subtype, "error"):
+		return SessionAPIError
+	}
+	return ""
+}
+
+// transientStatuses are API statuses worth retrying: rate limits,
+// overload, and server errors.
+var transientStatuses = []int{408, 429, 500, 502, 503, 504, 529}
+
+// isTransient reports whether an API error came from a rate limit,
+// overload, or server error and is likely to pass on retry.
+func isTransient(result *Result) bool {
+	if result == nil {
+		return false
+	}
+	if result.APIStatus != nil {
+		for _, status := range transientStatuses {
+			if *result.APIStatus == status {
+				return true
+			}
+		}
+		return false
+	}
+	text := strings.ToLower(result.Text)
+	return strings.Contains(text, "overloaded") || strings.Contains(text, "rate limit")
+}
+
+// retryTransient is the retry category for transient API errors.
+const retryTransient = "transient"
+
+// retryCategories are the session errors a RetryPolicy can retry. max_turns
+// is not one: running out of turns is deterministic, so a retry replays it.
+var retryCategories = []string{retryTransient, SessionAPIError, SessionCLICrash, SessionTimeout}
+
+// RetryPolicy controls retries of prompts that end in a session error.
+// Nothing is retried unless Attempts is set. Each retry resumes from the
+// same point in the conversation as the failed attempt.
+type RetryPolicy struct {
+	Attempts   int      `yaml:"attempts"`    // retries after the first try (default 0)
+	Backoff    string   `yaml:"backoff"`     // delay before the first retry, doubled after each (default 2s)
+	MaxBackoff string   `yaml:"max_backoff"` // cap on the delay (default 30s)
+	On         []string `yaml:"on"`          // categories to retry (default: transient)
+}
+
+// retries returns the number of retries allowed after a session error.
+func (p RetryPolicy) retries(category string, result *Result) int {
+	on := p.On
+	if len(on) == 0 {
+		on = []string{retryTransient}
+	}
+	for _, c := range on {
+		if c == category || (c == retryTransient && category == SessionAPIError && isTransient(result)) {
+			return p.Attempts
+		}
+	}
+	return 0
+}
+
+// delay returns the wait before retry n (1-based).
+func (p RetryPolicy) delay(n int) time.Duration {
+	backoff, maxBackoff := 2*time.Second, 30*time.Second
+	if d, err := time.ParseDuration(p.Backoff); err == nil {
+		backoff = d
+	}
+	if d, err := time.ParseDuration(p.MaxBackoff); err == nil {
+		maxBackoff = d
+	}
+	d := backoff
+	for i := 1; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// Validate checks the attempts, durations, and categories.
+func (p RetryPolicy) Validate() error {
+	if p.Attempts < 0 {
+		return fmt.Errorf("session_retry: attempts must not be negative")
+	}
+	for _, c := range p.On {
+		if !containsString(retryCategories, c) {
+			return fmt.Errorf("session_retry: cannot retry on %q (one of %s)", c, strings.Join(retryCategories, ", "))
+		}
+	}
+	for _, d := range []string{p.Backoff, p.MaxBackoff} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("session_retry: %w", err)
+		}
+	}
+	return nil
+}
+
+// SendOptions are the per-prompt Claude CLI options a step can set.
+type SendOptions struct {
+	MaxTurns           int
+	Timeout            time.Duration // kill claude after this (default 10m)
+	PermissionMode     string
+	DisallowedTools    []string
+	Model              string
+	AppendSystemPrompt string
+	Settings           string   // settings file or JSON string
+	MCPConfig          []string // MCP config files or JSON strings
+	ExtraArgs          []string // validated by validateExtraArgs
+}
+
+// permissionModes are the values --permission-mode accepts.
+var permissionModes = []string{"acceptEdits", "bypassPermissions", "default", "dontAsk", "plan"}
+
+// managedFlags are set by the harness itself and can't be overridden
+// through extra_args; the value names the typed step field to use instead.
+var managedFlags = map[string]string{
+	"-p":                     "prompt",
+	"--print":                "prompt",
+	"--output-format":        "",
+	"--input-format":         "",
+	"--verbose":              "",
+	"-r":                     "session",
+	"--resume":               "session",
+	"-c":                     "session",
+	"--continue":             "session",
+	"--session-id":           "session",
+	"--fork-session":         "fork_from",
+	"--plugin-dir":           "",
+	"--max-turns":            "max_turns",
+	"--allowedTools":         "allowed_tools",
+	"--allowed-tools":        "allowed_tools",
+	"--disallowedTools":      "disallowed_tools",
+	"--disallowed-tools":     "disallowed_tools",
+	"--permission-mode":      "permission_mode",
+	"--model":                "model",
+	"--append-system-prompt": "append_system_prompt",
+	"--settings":             "settings",
+	"--mcp-config":           "mcp_config",
+}
+
+// Validate checks the permission mode and that extra_args only holds
+// flags the harness doesn't manage.
+func (o SendOptions) Validate() error {
+	if o.PermissionMode != "" && !containsString(permissionModes, o.PermissionMode) {
+		return fmt.Errorf("permission_mode %q must be one of %s",
+			o.PermissionMode, strings.Join(permissionModes, ", "))
+	}
+	return validateExtraArgs(o.ExtraArgs)
+}
+
+// validateExtraArgs requires extra_args to start with a flag and rejects
+// flags that would break session chaining or JSON output parsing.
+func validateExtraArgs(args []string) error {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("extra_args must start with a flag, got %q", args[0])
+	}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		flag := strings.SplitN(arg, "=", 2)[0]
+		field, managed := managedFlags[flag]
+		switch {
+		case managed && field != "":
+			return fmt.Errorf("extra_args: %s is managed by the harness; use %s: instead", flag, field)
+		case managed:
+			return fmt.Errorf("extra_args: %s is managed by the harness", flag)
+		}
+	}
+	return nil
 }
 
 // Send runs a prompt in the session, resuming if a session ID exists.
-func (s *Session) Send(prompt string, maxTurns int, verbose bool) (*Result, error) {
+func (s *Session) Send(prompt string, opts SendOptions, verbose bool) (*Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 	args := []string{"-p", prompt, "--output-format", "json"}
 
-	if s.PluginDir != "" {
-		args = append(args, "--plugin-dir", s.PluginDir)
+	for _, dir := range s.PluginDirs {
+		args = append(args, "--plugin-dir", dir)
 	}
 	if s.ID != "" {
 		args = append(args, "--resume", s.ID)
+		if s.Fork {
+			args = append(args, "--fork-session")
+		}
 	}
-	if maxTurns > 0 {
-		args = append(args, "--max-turns", strconv.Itoa(maxTurns))
+	if opts.MaxTurns > 0 {
+		args = append(args, "--max-turns", strconv.Itoa(opts.MaxTurns))
 	}
 	if len(s.Allowed) > 0 {
 		args = append(args, "--allowedTools", strings.Join(s.Allowed, ","))
 	}
+	if len(opts.DisallowedTools) > 0 {
+		args = append(args, "--disallowedTools", strings.Join(opts.DisallowedTools, ","))
+	}
+	if opts.PermissionMode != "" {
+		args = append(args, "--permission-mode", opts.PermissionMode)
+	}
+	if opts.Model != "" {
+		args = append(args, "--model", opts.Model)
+	}
+	if opts.AppendSystemPrompt != "" {
+		args = append(args, "--append-system-prompt", opts.AppendSystemPrompt)
+	}
+	if opts.Settings != "" {
+		args = append(args, "--settings", opts.Settings)
+	}
+	if len(opts.MCPConfig) > 0 {
+		args = append(args, "--mcp-config")
+		args = append(args, opts.MCPConfig...)
+	}
+	args = append(args, opts.ExtraArgs...)
 
-	cmd := exec.Command("claude", args...)
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = defaultPromptTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := killableCommand(ctx, "claude", args...)
 	cmd.Dir = s.WorkDir
+	cmd.Env = s.Env.Vars()
+	for k, v := range s.ExtraEnv {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
 
 	if verbose {
 		fmt.Printf("    [claude] %s\n", strings.Join(args, " "))
 	}
 
-	output, err := cmd.CombinedOutput()
+	// Parse stdout alone; claude prints warnings to stderr
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	output := stdout.Bytes()
 	if verbose {
 		fmt.Printf("    [output] %s\n", string(output))
+		if stderr.Len() > 0 {
+			fmt.Printf("    [stderr] %s\n", stderr.String())
+		}
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return &Result{Raw: output, Stderr: stderr.String()},
+			fmt.Errorf("%w after %s", errSendTimeout, timeout)
 	}
 
 	// Try to parse JSON result even if exit code is non-zero
 	var result Result
 	if jsonErr := json.Unmarshal(output, &result); jsonErr != nil {
 		if err != nil {
-			return nil, fmt.Errorf("claude command failed: %w\nOutput: %s", err, string(output))
+			return &Result{Raw: output, Stderr: stderr.String()}, fmt.Errorf("claude command failed: %w\nOutput: %s%s", err, string(output), stderr.String())
 		}
 		// Non-JSON output but exit 0 — wrap as text
 		result.Text = string(output)
 	}
 
+	result.Raw, result.Stderr = output, stderr.String()
+
 	// Preserve session ID for chaining
 	if result.SessionID != "" {
 		s.ID = result.SessionID
+		s.Fork = false
 	}
 
 	return &result, nil
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// snapshotContentLimit is the largest file whose contents a snapshot keeps
+// for the per-file diffs in failure details.
+const snapshotContentLimit = 64 << 10
+
+// FileState records a single path at snapshot time.
+type FileState struct {
+	Mode    os.FileMode
+	Size    int64
+	Hash    string // sha256 of contents, or link target for symlinks
+	Content []byte // contents of regular files up to snapshotContentLimit
+}
+
+// Snapshot maps work-dir-relative paths (slash-separated) to their state.
+type Snapshot map[string]FileState
+
+// FSDiff lists the paths that changed between two snapshots.
+type FSDiff struct {
+	Created  []string
+	Modified []string
+	Deleted  []string
+
+	before, after Snapshot // for per-file content diffs
+}
+
+// takeSnapshot records every regular file and symlink under dir.
+// The .git directory is skipped; git state has its own assertions. So are
+// nested worktrees (directories whose .git is a file, such as
+// .claude/worktrees/*), which belong to their own checkout.
+func takeSnapshot(dir string) (Snapshot, error) {
+	snap := Snapshot{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			if path == dir {
+				return nil
+			}
+			if fi.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if gfi, err := os.Lstat(filepath.Join(path, ".git")); err == nil && gfi.Mode().IsRegular() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		state := FileState{Mode: fi.Mode(), Size: fi.Size()}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			state.Hash = "-> " + target
+		case fi.Mode().IsRegular():
+			hash, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			state.Hash = hash
+			if fi.Size() <= snapshotContentLimit {
+				if state.Content, err = os.ReadFile(path); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil // sockets, fifos, devices
+		}
+		snap[rel] = state
+		return nil
+	})
+	return snap, err
+}
+
+// hashFile returns the hex sha256 of a file's contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diffSnapshots compares two snapshots. Mode changes count as modifications.
+func diffSnapshots(before, after Snapshot) *FSDiff {
+	diff := &FSDiff{before: before, after: after}
+	for path, a := range after {
+		b, existed := before[path]
+		if !existed {
+			diff.Created = append(diff.Created, path)
+		} else if a.Hash != b.Hash || a.Mode != b.Mode {
+			diff.Modified = append(diff.Modified, path)
+		}
+	}
+	for path := range before {
+		if _, exists := after[path]; !exists {
+			diff.Deleted = append(diff.Deleted, path)
+		}
+	}
+	sort.Strings(diff.Created)
+	sort.Strings(diff.Modified)
+	sort.Strings(diff.Deleted)
+	return diff
+}
+
+// Changed returns every created, modified, or deleted path, sorted.
+func (d *FSDiff) Changed() []string {
+	var all []string
+	all = append(all, d.Created...)
+	all = append(all, d.Modified...)
+	all = append(all, d.Deleted...)
+	sort.Strings(all)
+	return all
+}
+
+// String renders the diff one path per line: "+" created, "~" modified, "-" deleted.
+func (d *FSDiff) String() string {
+	if len(d.Created)+len(d.Modified)+len(d.Deleted) == 0 {
+		return "(no filesystem changes)"
+	}
+	var lines []string
+	for _, p := range d.Created {
+		lines = append(lines, "+ "+p)
+	}
+	for _, p := range d.Modified {
+		lines = append(lines, "~ "+p)
+	}
+	for _, p := range d.Deleted {
+		lines = append(lines, "- "+p)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Detail renders String followed by each changed file's content diff,
+// truncated, for assertion failure details.
+func (d *FSDiff) Detail() string {
+	out := d.String()
+	for _, p := range d.Changed() {
+		if fd := d.fileDiff(p); fd != "" {
+			out += "\n" + fd
+		}
+	}
+	return out
+}
+
+// diffLinesShown caps the lines of each file's content diff in Detail.
+const diffLinesShown = 20
+
+// fileDiff renders one changed path as "--- path" followed by its removed
+// ("-") and added ("+") lines. It returns "" when the contents weren't kept.
+func (d *FSDiff) fileDiff(path string) string {
+	b, hadBefore := d.before[path]
+	a, hasAfter := d.after[path]
+	old, ok := fileLines(b, hadBefore)
+	if !ok {
+		return ""
+	}
+	cur, ok := fileLines(a, hasAfter)
+	if !ok {
+		return ""
+	}
+
+	var lines []string
+	if hadBefore && hasAfter && b.Mode != a.Mode {
+		lines = append(lines, fmt.Sprintf("mode %v -> %v", b.Mode, a.Mode))
+	}
+	lines = append(lines, diffLines(old, cur)...)
+	if len(lines) == 0 {
+		return ""
+	}
+	if len(lines) > diffLinesShown {
+		lines = append(lines[:diffLinesShown], fmt.Sprintf("... (%d more lines)", len(lines)-diffLinesShown))
+	}
+	return "--- " + path + "\n  " + strings.Join(lines, "\n  ")
+}
+
+// fileLines splits a snapshotted file into lines; a symlink is its target.
+// It reports false for binary files and files too large to keep.
+func fileLines(state FileState, exists bool) ([]string, bool) {
+	switch {
+	case !exists:
+		return nil, true
+	case state.Mode&os.ModeSymlink != 0:
+		return []string{state.Hash}, true
+	case state.Content == nil && state.Size > 0, strings.ContainsRune(string(state.Content), 0):
+		return nil, false
+	}
+	text := strings.TrimSuffix(string(state.Content), "\n")
+	if text == "" {
+		return nil, true
+	}
+	return strings.Split(text, "\n"), true
+}
+
+// diffLines returns the lines removed from old ("-") and added in cur
+// ("+"), in order, using a longest-common-subsequence table. Inputs too
+// large for the table are shown as wholly replaced.
+func diffLines(old, cur []string) []string {
+	var out []string
+	if len(old)*len(cur) > 1<<20 {
+		for _, l := range old {
+			out = append(out, "-"+l)
+		}
+		for _, l := range cur {
+			out = append(out, "+"+l)
+		}
+		return out
+	}
+
+	// lcs[i][j] is the LCS length of old[i:] and cur[j:]
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(cur)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(cur) - 1; j >= 0; j-- {
+			if old[i] == cur[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(old) || j < len(cur) {
+		switch {
+		case i < len(old) && j < len(cur) && old[i] == cur[j]:
+			i++
+			j++
+		case i < len(old) && (j == len(cur) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "-"+old[i])
+			i++
+		default:
+			out = append(out, "+"+cur[j])
+			j++
+		}
+	}
+	return out
+}
+
+// matchAny returns the paths that match the glob pattern.
+func matchAny(pattern string, paths []string) []string {
+	var matched []string
+	for _, p := range paths {
+		if matchGlob(pattern, p) {
+			matched = append(matched, p)
+		}
+	}
+	return matched
+}
+
+// matchGlob matches a slash-separated path against a glob pattern.
+// In addition to filepath.Match syntax, a "**" segment matches zero or
+// more whole path segments.
+func matchGlob(pattern, path string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(rest, path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		ok, err := filepath.Match(pattern[0], path[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// needsSnapshot reports whether any assertion inspects filesystem changes.
+func needsSnapshot(assertions []Assertion) bool {
+	for _, a := range assertions {
+		switch a.Type {
+		case "files_created", "files_modified", "files_deleted", "only_changed":
+			return true
+		}
+	}
+	return false
+}
+
+// checkDiffAssertion evaluates the filesystem diff assertion types.
+func checkDiffAssertion(a Assertion, diff *FSDiff) (bool, string) {
+	if diff == nil {
+		return false, "no filesystem snapshot was taken for this step"
+	}
+
+	var paths []string
+	var verb string
+	switch a.Type {
+	case "files_created":
+		paths, verb = diff.Created, "created"
+	case "files_modified":
+		paths, verb = diff.Modified, "modified"
+	case "files_deleted":
+		paths, verb = diff.Deleted, "deleted"
+	case "only_changed":
+		globs := assertionGlobs(a)
+		var stray []string
+		for _, p := range diff.Changed() {
+			allowed := false
+			for _, g := range globs {
+				if matchGlob(g, p) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				stray = append(stray, p)
+			}
+		}
+		if len(stray) > 0 {
+			return false, fmt.Sprintf("unexpected changes outside %v: %s\n%s",
+				globs, strings.Join(stray, ", "), diff.Detail())
+		}
+		return true, ""
+	}
+
+	// No globs means "at least one file"; otherwise every glob must match
+	globs := assertionGlobs(a)
+	if len(globs) == 0 {
+		if len(paths) == 0 {
+			return false, fmt.Sprintf("no files %s\n%s", verb, diff.Detail())
+		}
+		return true, ""
+	}
+	for _, g := range globs {
+		if len(matchAny(g, paths)) == 0 {
+			return false, fmt.Sprintf("no %s file matches %q\n%s", verb, g, diff.Detail())
+		}
+	}
+	return true, ""
+}
+
+// assertionGlobs merges an assertion's single path and path list.
+func assertionGlobs(a Assertion) []string {
+	var globs []string
+	if a.Path != "" {
+		globs = append(globs, a.Path)
+	}
+	return append(globs, a.Paths...)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TemplateCache keeps one provisioned project per distinct ProjectConfig.
+// The first scenario with a given config builds the template; every
+// scenario, including that one, works on a copy with the remote rewritten.
+type TemplateCache struct {
+	templates map[string]*TestProject
+	Built     int
+	Hits      int
+}
+
+// NewTemplateCache returns an empty cache.
+func NewTemplateCache() *TemplateCache {
+	return &TemplateCache{templates: map[string]*TestProject{}}
+}
+
+// Provision returns a fresh copy of the template for cfg, building it first
+// if this config hasn't been seen in the run. Failed builds aren't cached.
+func (c *TemplateCache) Provision(realPluginDir string, cfg ProjectConfig, env *Environ) (*TestProject, error) {
+	key, err := templateKey(realPluginDir, cfg, env)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, ok := c.templates[key]
+	if ok {
+		c.Hits++
+	} else {
+		tmpl, err = ProvisionProject(realPluginDir, cfg, env)
+		if err != nil {
+			return tmpl, err
+		}
+		// Worktrees placed outside the temp root can't be copied with it
+		for _, p := range tmpl.Worktrees {
+			if !isWithin(tmpl.BaseDir, p) {
+				return tmpl, nil
+			}
+		}
+		c.templates[key] = tmpl
+		c.Built++
+	}
+	return cloneProject(tmpl, env)
+}
+
+// Cleanup removes every cached template.
+func (c *TemplateCache) Cleanup() {
+	for _, tmpl := range c.templates {
+		tmpl.Cleanup()
+	}
+	c.templates = map[string]*TestProject{}
+}
+
+// templateKey identifies a config by the hash of its JSON encoding. The
+// environment mode and pinned time change what gets provisioned, so both
+// are part of the key.
+func templateKey(realPluginDir string, cfg ProjectConfig, env *Environ) (string, error) {
+	hermetic := env != nil && env.Hermetic
+	var pass []string
+	if hermetic {
+		pass = env.Pass
+	}
+	var now string
+	if env != nil && env.Clock != nil {
+		now = env.Clock.Now().Format(time.RFC3339)
+	}
+	data, err := json.Marshal(struct {
+		PluginDir string
+		Config    ProjectConfig
+		Hermetic  bool
+		Pass      []string
+		Now       string
+	}{realPluginDir, cfg, hermetic, pass, now})
+	if err != nil {
+		return "", fmt.Errorf("template key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cloneProject copies a template into a new temp root and points the copy's
+// origin and worktrees at their new locations.
+func cloneProject(tmpl *TestProject, env *Environ) (*TestProject, error) {
+	baseDir, err := os.MkdirTemp("", "test-project-*")
+	if err != nil {
+		return nil, fmt.Errorf("mkdirTemp: %w", err)
+	}
+	project := &TestProject{
+		BaseDir:       baseDir,
+		DefaultBranch: tmpl.DefaultBranch,
+		StoreIDs:      tmpl.StoreIDs,
+		Preflight:     tmpl.Preflight,
+	}
+	if err := copyTree(tmpl.BaseDir, baseDir); err != nil {
+		return project, fmt.Errorf("copying template: %w", err)
+	}
+
+	rebase := func(p string) string {
+		if p == "" || !isWithin(tmpl.BaseDir, p) {
+			return p
+		}
+		rel, _ := filepath.Rel(tmpl.BaseDir, p)
+		return filepath.Join(baseDir, rel)
+	}
+	project.RemoteDir = rebase(tmpl.RemoteDir)
+	project.WorkDir = rebase(tmpl.WorkDir)
+	project.PluginDir = rebase(tmpl.PluginDir)
+	if len(tmpl.Plugins) > 0 {
+		project.Plugins = map[string]string{}
+		for name, p := range tmpl.Plugins {
+			project.Plugins[name] = rebase(p)
+		}
+	}
+
+	if err := runGit(env, project.WorkDir, "remote", "set-url", "origin", project.RemoteDir); err != nil {
+		return project, err
+	}
+
+	if len(tmpl.Worktrees) > 0 {
+		project.Worktrees = map[string]string{}
+		for name, p := range tmpl.Worktrees {
+			project.Worktrees[name] = rebase(p)
+		}
+		if err := relinkWorktrees(project, tmpl.BaseDir); err != nil {
+			return project, err
+		}
+	}
+
+	return project, nil
+}
+
+// relinkWorktrees rewrites the absolute paths that tie linked worktrees to
+// the main repository. `git worktree repair` can't be used here: the copied
+// links still resolve to the template, so it would repair the template instead.
+func relinkWorktrees(project *TestProject, oldBase string) error {
+	var files []string
+	for _, wt := range project.Worktrees {
+		files = append(files, filepath.Join(wt, ".git"))
+	}
+	admin, _ := filepath.Glob(filepath.Join(project.WorkDir, ".git", "worktrees", "*", "gitdir"))
+	files = append(files, admin...)
+
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("relinking worktree: %w", err)
+		}
+		updated := strings.ReplaceAll(string(data), oldBase+string(filepath.Separator), project.BaseDir+string(filepath.Separator))
+		if err := os.WriteFile(f, []byte(updated), 0644); err != nil {
+			return fmt.Errorf("relinking worktree: %w", err)
+		}
+	}
+	return nil
+}
+
+// isWithin reports whether path lies inside dir.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WorktreeSpec describes a git worktree created during provisioning.
+type WorktreeSpec struct {
+	Name   string `yaml:"name"`
+	Branch string `yaml:"branch"` // default: worktree/<name>, as worktree-create.sh names it
+	From   string `yaml:"from"`   // start point for a new branch (default: HEAD)
+	Path   string `yaml:"path"`   // relative to the project (default: .claude/worktrees/<name>)
+}
+
+// WorktreeInfo is one entry of `git worktree list --porcelain`.
+type WorktreeInfo struct {
+	Path   string
+	Branch string // short branch name; empty when detached
+	Head   string
+}
+
+// createWorktrees adds the configured worktrees and returns name -> absolute path.
+func createWorktrees(env *Environ, workDir string, specs []WorktreeSpec) (map[string]string, error) {
+	paths := map[string]string{}
+	for _, wt := range specs {
+		if wt.Name == "" {
+			return nil, fmt.Errorf("worktree without a name")
+		}
+		if _, dup := paths[wt.Name]; dup {
+			return nil, fmt.Errorf("duplicate worktree %q", wt.Name)
+		}
+
+		rel := wt.Path
+		if rel == "" {
+			rel = filepath.Join(".claude", "worktrees", wt.Name)
+		}
+		abs := filepath.Join(workDir, rel)
+		branch := wt.Branch
+		if branch == "" {
+			branch = "worktree/" + wt.Name
+		}
+		from := wt.From
+		if from == "" {
+			from = "HEAD"
+		}
+
+		// Reuse an existing branch; otherwise create it from the start point
+		var args []string
+		if _, err := runGitOutput(env, workDir, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err == nil {
+			args = []string{"worktree", "add", abs, branch}
+		} else {
+			args = []string{"worktree", "add", "-b", branch, abs, from}
+		}
+		if err := runGit(env, workDir, args...); err != nil {
+			return nil, fmt.Errorf("worktree %s: %w", wt.Name, err)
+		}
+		paths[wt.Name] = abs
+	}
+	return paths, nil
+}
+
+// listWorktrees parses `git worktree list --porcelain` run from dir.
+// The main worktree is always first.
+func listWorktrees(env *Environ, dir string) ([]WorktreeInfo, error) {
+	out, err := runGitOutput(env, dir, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	var list []WorktreeInfo
+	for _, block := range strings.Split(out, "\n\n") {
+		var wt WorktreeInfo
+		for _, line := range strings.Split(strings.TrimSpace(block), "\n") {
+			switch {
+			case strings.HasPrefix(line, "worktree "):
+				wt.Path = strings.TrimPrefix(line, "worktree ")
+			case strings.HasPrefix(line, "HEAD "):
+				wt.Head = strings.TrimPrefix(line, "HEAD ")
+			case strings.HasPrefix(line, "branch "):
+				wt.Branch = strings.TrimPrefix(strings.TrimPrefix(line, "branch "), "refs/heads/")
+			}
+		}
+		if wt.Path != "" {
+			list = append(list, wt)
+		}
+	}
+	return list, nil
+}
+
+// findWorktree locates a worktree by path. Relative paths are resolved
+// against the main worktree.
+func findWorktree(list []WorktreeInfo, path string) *WorktreeInfo {
+	if len(list) == 0 {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(list[0].Path, path)
+	}
+	want := canonicalPath(path)
+	for i := range list {
+		if canonicalPath(list[i].Path) == want {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// canonicalPath resolves symlinks (e.g. macOS /var -> /private/var) for comparison.
+func canonicalPath(p string) string {
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		return resolved
+	}
+	return filepath.Clean(p)
+}
+
+// checkWorktreeAssertion evaluates the worktree_* assertion types.
+func checkWorktreeAssertion(env *Environ, workDir string, a Assertion) (bool, string) {
+	list, err := listWorktrees(env, workDir)
+	if err != nil {
+		return false, fmt.Sprintf("git worktree list failed: %v", err)
+	}
+	var summary []string
+	for _, wt := range list {
+		summary = append(summary, fmt.Sprintf("%s [%s]", wt.Path, wt.Branch))
+	}
+
+	switch a.Type {
+	case "worktree_exists":
+		if findWorktree(list, a.Path) == nil {
+			return false, fmt.Sprintf("no worktree at %q (have: %s)", a.Path, strings.Join(summary, ", "))
+		}
+	case "worktree_branch":
+		wt := findWorktree(list, a.Path)
+		if wt == nil {
+			return false, fmt.Sprintf("no worktree at %q (have: %s)", a.Path, strings.Join(summary, ", "))
+		}
+		if wt.Branch != a.Value {
+			return false, fmt.Sprintf("worktree %q is on %q, expected %q", a.Path, wt.Branch, a.Value)
+		}
+	case "worktree_count":
+		expected, err := strconv.Atoi(a.Value)
+		if err != nil {
+			return false, fmt.Sprintf("invalid worktree_count value %q", a.Value)
+		}
+		if len(list) != expected {
+			return false, fmt.Sprintf("worktree count %d != expected %d (have: %s)",
+				len(list), expected, strings.Join(summary, ", "))
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// yfConfigSchema lists the .yoshiko-flow/config.json keys that yf-config.sh
+// and the scripts sourcing it read. Leaves name the expected JSON type:
+// "bool", "string", "number", or a "|"-separated list of allowed strings.
+var yfConfigSchema = map[string]interface{}{
+	"enabled": "bool",
+	"config": map[string]interface{}{
+		"artifact_dir": "string",
+		"operator":     "string",
+		"plugin_repo":  "string",
+		"auto_prune": map[string]interface{}{
+			"on_plan_complete": "bool",
+			"on_push":          "bool",
+			"on_session_close": "bool",
+			"older_than_days":  "number",
+		},
+		"engineer": map[string]interface{}{
+			"sanity_check_mode":   "blocking|advisory|disabled",
+			"reconciliation_mode": "blocking|advisory|disabled",
+		},
+		"project_tracking": map[string]interface{}{
+			"tracker":      "github|gitlab|file|auto",
+			"project":      "string",
+			"tracker_tool": "gh|glab",
+		},
+	},
+}
+
+// defaultYfConfig returns the config.json written when yf_enabled is set.
+func defaultYfConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"enabled": true,
+		"config":  map[string]interface{}{"artifact_dir": "docs"},
+	}
+}
+
+// buildYfConfig deep-merges overrides over the defaults, validates the
+// result against yfConfigSchema, and returns it as indented JSON.
+func buildYfConfig(overrides map[string]interface{}) ([]byte, error) {
+	merged := deepMerge(defaultYfConfig(), overrides)
+	if errs := validateYfConfig(merged, yfConfigSchema, ""); len(errs) > 0 {
+		return nil, fmt.Errorf("yf_config: %s", strings.Join(errs, "; "))
+	}
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("yf_config: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// deepMerge merges src into dst recursively, as jq's `*` operator does.
+func deepMerge(dst, src map[string]interface{}) map[string]interface{} {
+	for k, sv := range src {
+		if sm, ok := sv.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				dst[k] = deepMerge(dm, sm)
+				continue
+			}
+		}
+		dst[k] = sv
+	}
+	return dst
+}
+
+// validateYfConfig checks keys and leaf types, returning one message per problem.
+func validateYfConfig(cfg map[string]interface{}, schema map[string]interface{}, prefix string) []string {
+	var errs []string
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := cfg[k]
+		path := prefix + "." + k
+		spec, known := schema[k]
+		if !known {
+			errs = append(errs, fmt.Sprintf("unknown key %s", path))
+			continue
+		}
+
+		if sub, ok := spec.(map[string]interface{}); ok {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s must be an object", path))
+				continue
+			}
+			errs = append(errs, validateYfConfig(m, sub, path)...)
+			continue
+		}
+
+		kind := spec.(string)
+		switch kind {
+		case "bool":
+			if _, ok := v.(bool); !ok {
+				errs = append(errs, fmt.Sprintf("%s must be a boolean", path))
+			}
+		case "number":
+			switch v.(type) {
+			case int, int64, float64:
+			default:
+				errs = append(errs, fmt.Sprintf("%s must be a number", path))
+			}
+		case "string":
+			if _, ok := v.(string); !ok {
+				errs = append(errs, fmt.Sprintf("%s must be a string", path))
+			}
+		default:
+			s, ok := v.(string)
+			if !ok || !containsString(strings.Split(kind, "|"), s) {
+				errs = append(errs, fmt.Sprintf("%s must be one of %s", path, kind))
+			}
+		}
+	}
+	return errs
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// StoreEntity declares one yf task-store entity to seed during provisioning.
+// Files are written in the layout yf-tasks.sh uses, so scripts under test
+// see them exactly as if yft_create had produced them.
+type StoreEntity struct {
+	Key          string   `yaml:"key"`  // scenario handle; exported to steps as $YF_ID_<KEY>
+	ID           string   `yaml:"id"`   // explicit ID (default: generated hybrid ID)
+	Type         string   `yaml:"type"` // task (default), epic, gate, chronicle, archive, issue, todo, molecule
+	Title        string   `yaml:"title"`
+	Description  string   `yaml:"description"`
+	Status       string   `yaml:"status"` // open (default), in_progress, closed, deferred
+	Priority     int      `yaml:"priority"`
+	Labels       []string `yaml:"labels"`
+	Parent       string   `yaml:"parent"`     // key or ID of an epic
+	Dependencies []string `yaml:"depends_on"` // keys or IDs
+	Defer        string   `yaml:"defer"`      // any non-empty value defers (yf-tasks.sh stores only the flag)
+	Reason       string   `yaml:"reason"`     // close/resolve reason for closed entities
+	Created      string   `yaml:"created"`    // RFC 3339 (default: the scenario clock)
+}
+
+// storeRecord mirrors the JSON object yft_create writes for tasks, epics,
+// and other regular entities. Field order matches the jq templates.
+type storeRecord struct {
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	Title        string   `json:"title"`
+	Status       string   `json:"status"`
+	Priority     int      `json:"priority"`
+	Description  string   `json:"description"`
+	Design       string   `json:"design"`
+	Acceptance   string   `json:"acceptance"`
+	Notes        string   `json:"notes"`
+	Labels       []string `json:"labels"`
+	Parent       *string  `json:"parent"`
+	Dependencies []string `json:"dependencies"`
+	Deferred     bool     `json:"deferred"`
+	Comments     []string `json:"comments"`
+	Created      string   `json:"created"`
+	Updated      string   `json:"updated"`
+	ClosedAt     *string  `json:"closed_at"`
+	CloseReason  *string  `json:"close_reason"`
+}
+
+// gateRecord mirrors the JSON object yft_create writes for gates.
+type gateRecord struct {
+	ID            string   `json:"id"`
+	Type          string   `json:"type"`
+	Title         string   `json:"title"`
+	Status        string   `json:"status"`
+	Priority      int      `json:"priority"`
+	Description   string   `json:"description"`
+	Labels        []string `json:"labels"`
+	Parent        string   `json:"parent"`
+	Dependencies  []string `json:"dependencies"`
+	Deferred      bool     `json:"deferred"`
+	Comments      []string `json:"comments"`
+	Resolved      bool     `json:"resolved"`
+	ResolveReason *string  `json:"resolve_reason"`
+	Created       string   `json:"created"`
+	Updated       string   `json:"updated"`
+	ClosedAt      *string  `json:"closed_at"`
+	CloseReason   *string  `json:"close_reason"`
+}
+
+// storeTypeDir mirrors _yft_type_dir in yf-tasks.sh.
+func storeTypeDir(typ string) string {
+	switch typ {
+	case "chronicle":
+		return "chronicler"
+	case "archive":
+		return "archivist"
+	case "issue":
+		return "issues"
+	case "todo":
+		return "todos"
+	case "molecule", "mol":
+		return "molecules"
+	default:
+		return "tasks"
+	}
+}
+
+// storeTypePrefix mirrors _yft_type_prefix in yf-tasks.sh.
+func storeTypePrefix(typ string) string {
+	switch typ {
+	case "chronicle":
+		return "chron"
+	case "archive":
+		return "arch"
+	case "issue":
+		return "issue"
+	case "todo":
+		return "todo"
+	case "molecule", "mol":
+		return "mol"
+	default:
+		return "task"
+	}
+}
+
+// storeDirs are created up front, as _yft_ensure_dirs does.
+var storeDirs = []string{"tasks", "chronicler", "archivist", "issues", "todos", "molecules"}
+
+// seedStore writes the declared entities under <workDir>/.yoshiko-flow and
+// returns the assigned IDs keyed by entity key.
+func seedStore(workDir string, entities []StoreEntity, now time.Time) (map[string]string, error) {
+	root := filepath.Join(workDir, ".yoshiko-flow")
+	for _, d := range storeDirs {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	// parent: and depends_on: name a key or the ID of a seeded entity
+	ids := map[string]string{}
+	seeded := map[string]bool{}
+	resolve := func(e StoreEntity, field, ref string) (string, error) {
+		if id, ok := ids[ref]; ok {
+			return id, nil
+		}
+		if seeded[ref] {
+			return ref, nil
+		}
+		return "", fmt.Errorf("yf_store: entity %q: %s %q is not the key or ID of a seeded entity", e.label(), field, ref)
+	}
+
+	// Pass 1: assign IDs. Top-level entities first so children can derive
+	// "<parent>.NN" IDs regardless of declaration order.
+	assigned := make([]string, len(entities))
+	prefixIdx := map[string]int{}
+	childIdx := map[string]int{}
+	epicIDs := map[string]bool{}
+	for _, top := range []bool{true, false} {
+		for i, e := range entities {
+			if (e.Parent == "") != top {
+				continue
+			}
+			typ := storeEntityType(e)
+			id := e.ID
+			if e.Parent != "" && typ != "gate" {
+				parent, err := resolve(e, "parent", e.Parent)
+				if err != nil {
+					return nil, err
+				}
+				childIdx[parent]++
+				epicIDs[parent] = true
+				if id == "" {
+					id = fmt.Sprintf("%s.%02d", parent, childIdx[parent])
+				}
+			} else if id == "" {
+				prefix := storeTypePrefix(typ)
+				prefixIdx[prefix]++
+				id = hybridID(prefix, prefixIdx[prefix], fmt.Sprintf("%d:%s:%s", i, e.Key, e.Title))
+			}
+			assigned[i] = id
+			seeded[id] = true
+			if top && typ == "epic" {
+				epicIDs[id] = true
+			}
+			if e.Key != "" {
+				if _, dup := ids[e.Key]; dup {
+					return nil, fmt.Errorf("yf_store: duplicate key %q", e.Key)
+				}
+				ids[e.Key] = id
+			}
+		}
+	}
+
+	// Pass 2: write files
+	stamp := now.UTC().Format("2006-01-02T15:04:05Z")
+	for i, e := range entities {
+		typ := storeEntityType(e)
+		id := assigned[i]
+		dir := filepath.Join(root, storeTypeDir(typ))
+		var parent string
+		if e.Parent != "" {
+			var err error
+			if parent, err = resolve(e, "parent", e.Parent); err != nil {
+				return nil, err
+			}
+		}
+		deps := []string{}
+		for _, d := range e.Dependencies {
+			dep, err := resolve(e, "depends_on", d)
+			if err != nil {
+				return nil, err
+			}
+			deps = append(deps, dep)
+		}
+
+		// Epics, and any entity that gained children, live in their own
+		// directory as _epic.json; gates join their parent only if it is one.
+		var path string
+		switch {
+		case e.Parent == "" && (typ == "epic" || childIdx[id] > 0):
+			path = filepath.Join(dir, id, "_epic.json")
+		case parent != "" && (typ != "gate" || epicIDs[parent]):
+			path = filepath.Join(dir, parent, id+".json")
+		default:
+			path = filepath.Join(dir, id+".json")
+		}
+
+		record, err := storeRecordFor(e, typ, id, stamp, parent, deps)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
+
+// label names the entity in errors: its key, or its title.
+func (e StoreEntity) label() string {
+	if e.Key != "" {
+		return e.Key
+	}
+	return e.Title
+}
+
+func storeEntityType(e StoreEntity) string {
+	if e.Type == "" {
+		return "task"
+	}
+	return e.Type
+}
+
+// storeRecordFor builds the JSON record for one entity; parent and deps
+// are already resolved to IDs.
+func storeRecordFor(e StoreEntity, typ, id, now, parent string, deps []string) (interface{}, error) {
+	if e.Title == "" {
+		return nil, fmt.Errorf("yf_store: entity %q has no title", id)
+	}
+
+	created := e.Created
+	if created == "" {
+		created = now
+	}
+	status := e.Status
+	if status == "" {
+		status = "open"
+	}
+	deferred := e.Defer != ""
+	if deferred && e.Status == "" {
+		status = "deferred"
+	}
+	switch status {
+	case "open", "in_progress", "closed", "deferred":
+	default:
+		return nil, fmt.Errorf("yf_store: entity %q has unknown status %q", id, status)
+	}
+	priority := e.Priority
+	if priority == 0 {
+		priority = 3
+	}
+	labels := e.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+
+	var closedAt, closeReason *string
+	if status == "closed" {
+		closedAt, closeReason = &created, &e.Reason
+	}
+
+	if typ == "gate" {
+		rec := gateRecord{
+			ID: id, Type: "gate", Title: e.Title, Status: status,
+			Priority: priority, Description: e.Description, Labels: labels,
+			Parent: parent, Dependencies: deps, Comments: []string{},
+			Created: created, Updated: created, ClosedAt: closedAt, CloseReason: closeReason,
+		}
+		if status == "closed" {
+			rec.Resolved = true
+			rec.ResolveReason = closeReason
+		}
+		return rec, nil
+	}
+
+	var parentRef *string
+	if parent != "" {
+		parentRef = &parent
+	}
+	return storeRecord{
+		ID: id, Type: typ, Title: e.Title, Status: status,
+		Priority: priority, Description: e.Description, Labels: labels,
+		Parent: parentRef, Dependencies: deps, Deferred: deferred, Comments: []string{},
+		Created: created, Updated: created, ClosedAt: closedAt, CloseReason: closeReason,
+	}, nil
+}
+
+// hybridID builds a PREFIX-NNNN-xxxxx ID as yf_generate_id does, with the
+// hash part derived from seed so seeded stores are reproducible.
+func hybridID(prefix string, idx int, seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return fmt.Sprintf("%s-%04d-%s", prefix, idx, base36Suffix(hex.EncodeToString(sum[:])))
+}
+
+// base36Suffix mirrors _yf_base36 in yf-id.sh: the low five base36 digits
+// of the number in the first 16 hex characters.
+func base36Suffix(hexStr string) string {
+	if len(hexStr) > 16 {
+		hexStr = hexStr[:16]
+	}
+	n := new(big.Int)
+	n.SetString(hexStr, 16)
+	b36 := n.Text(36)
+	if len(b36) < 5 {
+		b36 = strings.Repeat("0", 5-len(b36)) + b36
+	}
+	return b36[len(b36)-5:]
+}
+
+var envKeyRe = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// storeIDEnv maps an entity key to its environment variable name.
+func storeIDEnv(key string) string {
+	return "YF_ID_" + strings.ToUpper(envKeyRe.ReplaceAllString(key, "_"))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// yfIDScript is yf-id.sh in the plugin tree this harness lives in.
+var yfIDScript = filepath.Join("..", "..", "plugins", "yf", "scripts", "yf-id.sh")
+
+// yfID runs a yf-id.sh function with args and returns its trimmed output.
+func yfID(t *testing.T, fn string, args ...string) string {
+	t.Helper()
+	if _, err := os.Stat(yfIDScript); err != nil {
+		t.Skipf("yf-id.sh not found: %v", err)
+	}
+	cmd := exec.Command("bash", append([]string{"-c", `. "$0"; "$@"`, yfIDScript, fn}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s %v: %v", fn, args, err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestBase36SuffixMatchesYfID(t *testing.T) {
+	hexes := []string{
+		"0000000000000000",
+		"0000000000000023",
+		"ffffffffffffffff",
+		"00000000000000000000ffff",
+	}
+	for _, seed := range []string{"", "a", "0:task:Title", "1700000000-42-1234-1"} {
+		sum := sha256.Sum256([]byte(seed))
+		hexes = append(hexes, hex.EncodeToString(sum[:]))
+	}
+	for _, h := range hexes {
+		want := yfID(t, "_yf_base36", h, "5")
+		if got := base36Suffix(h); got != want {
+			t.Errorf("base36Suffix(%s) = %q, _yf_base36 = %q", h, got, want)
+		}
+	}
+}
+
+func TestHybridIDMatchesYfGenerateID(t *testing.T) {
+	shape := regexp.MustCompile(`^([a-z]+-\d{4})-[0-9a-z]{5}$`)
+	for _, prefix := range []string{"task", "chron", "arch", "issue", "todo", "mol"} {
+		want := yfID(t, "yf_generate_id", prefix, t.TempDir())
+		got := hybridID(prefix, 1, "seed")
+		wm, gm := shape.FindStringSubmatch(want), shape.FindStringSubmatch(got)
+		if wm == nil || gm == nil || wm[1] != gm[1] {
+			t.Errorf("hybridID = %q, yf_generate_id = %q", got, want)
+		}
+	}
+}
+
+func TestSeedStoreResolvesKeysAndIDs(t *testing.T) {
+	entities := []StoreEntity{
+		{Key: "child", Title: "Child", Parent: "epic", Dependencies: []string{"other"}},
+		{Key: "epic", Type: "epic", Title: "Epic"},
+		{ID: "task-0042-abcde", Title: "Other"},
+		{Key: "other", Title: "Other by key", Dependencies: []string{"task-0042-abcde"}},
+	}
+	dir := t.TempDir()
+	ids, err := seedStore(dir, entities, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ids["epic"] + ".01"; ids["child"] != want {
+		t.Errorf("child ID = %q, want %q", ids["child"], want)
+	}
+	for _, rel := range []string{
+		filepath.Join("tasks", ids["epic"], "_epic.json"),
+		filepath.Join("tasks", ids["epic"], ids["child"]+".json"),
+		filepath.Join("tasks", "task-0042-abcde.json"),
+	} {
+		if _, err := os.Stat(filepath.Join(dir, ".yoshiko-flow", rel)); err != nil {
+			t.Errorf("missing %s: %v", rel, err)
+		}
+	}
+}
+
+func TestSeedStoreUnknownRef(t *testing.T) {
+	for _, e := range []StoreEntity{
+		{Key: "a", Title: "A", Parent: "no-such-epic"},
+		{Key: "a", Title: "A", Dependencies: []string{"no-such-task"}},
+	} {
+		_, err := seedStore(t.TempDir(), []StoreEntity{e}, time.Now())
+		if err == nil {
+			t.Errorf("%+v: seeded without error", e)
+			continue
+		}
+		ref := e.Parent
+		if ref == "" {
+			ref = e.Dependencies[0]
+		}
+		if !strings.Contains(err.Error(), ref) {
+			t.Errorf("error %q doesn't name %q", err, ref)
+		}
+	}
+}